@@ -1,11 +1,12 @@
 package app
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
-	"io/ioutil"
 	"net"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -13,7 +14,7 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-// Backend represents a tunnel to an app reached by a SSH tunnel
+// Backend represents a tunnel to an app reached by a SSH tunnel, or directly
 type Backend interface {
 	ID() int
 	Start()
@@ -21,19 +22,44 @@ type Backend interface {
 	Connect() net.Conn
 	GetInfo() PathInfo
 	Subscribe(chan progressCmd)
+	Unsubscribe(chan progressCmd)
 	GetLogger() *logrus.Entry
+	Stop()
+	Wait()
+	Address() string
+	TLSConfig() (*tls.Config, error)
 }
 
+var errBackendStopped = errors.New("Backend stopped")
+
 type backendStruct struct {
-	id                int
-	info              PathInfo
-	log               *logrus.Entry
-	subscribeProgress chan chan progressCmd
-	getConn           chan chan net.Conn
-	progress          chan progressCmd
-	start             chan bool
-	isReady           bool
-	sshConfig         *ssh.ClientConfig
+	// Accessed atomically, and first in the struct to be 64-bit aligned.
+	lastActivity int64
+	activeConns  int32
+
+	id                  int
+	info                PathInfo
+	log                 *logrus.Entry
+	baseLog             *logrus.Entry
+	infoLock            sync.Mutex
+	subscribeProgress   chan chan progressCmd
+	unsubscribeProgress chan chan progressCmd
+	getConn             chan chan net.Conn
+	progress            chan progressCmd
+	start               chan bool
+	stop                chan bool
+	stopOnce            sync.Once
+	done                chan bool
+	isReady             bool
+	sshHops             []sshHop
+	address             string
+	addressLock         sync.Mutex
+	discoveredAddress   chan string
+	tlsLock             sync.Mutex
+	tlsAddress          string
+	tlsBackend          *configBackend
+	tlsConfig           *tls.Config
+	tlsErr              error
 }
 
 func (b *backendStruct) ID() int {
@@ -41,7 +67,22 @@ func (b *backendStruct) ID() int {
 }
 
 func (b *backendStruct) Start() {
-	b.start <- true
+	select {
+	case b.start <- true:
+	default:
+	}
+}
+
+// Stop tears down the backend, closing its SSH connection and ending its goroutines.
+func (b *backendStruct) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+// Wait waits until the backend has stopped, and its teardown commands have run.
+func (b *backendStruct) Wait() {
+	<-b.done
 }
 
 func (b *backendStruct) IsReady() bool {
@@ -50,37 +91,88 @@ func (b *backendStruct) IsReady() bool {
 
 func (b *backendStruct) Connect() net.Conn {
 	reply := make(chan net.Conn, 1)
-	b.getConn <- reply
-	return <-reply
+	select {
+	case b.getConn <- reply:
+	case <-b.done:
+		return nil
+	}
+	select {
+	case conn := <-reply:
+		if conn != nil {
+			// Only proxied traffic keeps the backend from going idle, not e.g. progress pages.
+			b.touch()
+			conn = b.trackConn(conn)
+		}
+		return conn
+	case <-b.done:
+		return nil
+	}
 }
 
 func (b *backendStruct) Subscribe(sub chan progressCmd) {
-	b.subscribeProgress <- sub
+	select {
+	case b.subscribeProgress <- sub:
+	case <-b.done:
+		close(sub)
+	}
+}
+
+// Unsubscribe stops sending progress to a subscriber, and closes its channel.
+func (b *backendStruct) Unsubscribe(sub chan progressCmd) {
+	select {
+	case b.unsubscribeProgress <- sub:
+	case <-b.done:
+	}
 }
 
 func (b *backendStruct) GetInfo() PathInfo {
+	b.infoLock.Lock()
+	defer b.infoLock.Unlock()
 	return b.info
 }
 
 func (b *backendStruct) GetLogger() *logrus.Entry {
+	b.infoLock.Lock()
+	defer b.infoLock.Unlock()
 	return b.log
 }
 
-const maxRetriesServer = 15 * 60
-const maxRetriesClient = (10 * 60 / 5)
+// Replaces the path info, and the log fields that are taken from it. It's only called by the
+// monitor goroutine, which can read them without locking.
+func (b *backendStruct) setInfo(info PathInfo) {
+	fields := logrus.Fields{}
+	if info.SSHTunnel != nil {
+		fields["ssh_host"] = info.SSHTunnel.Address
+	}
+	if info.Backend != nil {
+		fields["backend"] = info.Backend.Address
+	}
 
-func generateKeepalive(client *ssh.Client) {
-	go func() {
-		t := time.NewTicker(2 * time.Second)
-		defer t.Stop()
-		for {
-			<-t.C
-			_, _, err := client.Conn.SendRequest("keepalive@openssh.com", true, nil)
-			if err != nil {
-				return
-			}
-		}
-	}()
+	b.infoLock.Lock()
+	defer b.infoLock.Unlock()
+	b.info = info
+	b.log = b.baseLog.WithFields(fields)
+}
+
+// Looks up the path info again when waking up after being idle, to pick up changes that were
+// made in the meantime, e.g. a new provisioning.
+func (b *backendStruct) refreshInfo() {
+	if externalLookupURL == "" {
+		return
+	}
+	if info := doLookup(b.info.Host, b.info.Prefix); info != nil {
+		b.setInfo(*info)
+	} else {
+		b.log.Warn("Failed to look up the path info again - keeping the previous one")
+	}
+}
+
+// Waits 5 seconds between attempts to connect to the backend, for up to 10 minutes
+var defaultBackendRetry = configRetryPolicy{
+	InitialDelay: configDuration(5 * time.Second),
+	Multiplier:   1,
+	MaxDelay:     configDuration(5 * time.Second),
+	Deadline:     configDuration(10 * time.Minute),
 }
 
 func (b *backendStruct) isProvisioned() bool {
@@ -97,12 +189,14 @@ func (b *backendStruct) waitProvisioned() error {
 				// TODO: Retry?
 				return errors.New("Failed to get info from backend")
 			}
-			b.info = *newInfo
+			b.setInfo(*newInfo)
 			if b.isProvisioned() {
 				break
 			}
 			b.log.Info("Provisioning - retry...")
-			time.Sleep(5 * time.Second)
+			if err := b.sleep(5 * time.Second); err != nil {
+				return err
+			}
 		}
 		BackendProvisioningDuration.Observe(time.Since(start).Seconds())
 		b.log.Info("Provisioning completed")
@@ -111,55 +205,53 @@ func (b *backendStruct) waitProvisioned() error {
 	return nil
 }
 
+// Keeps the backend in lame duck mode until it's stopped. The manager replaces it with a new
+// backend after the cool-down period.
 func (b *backendStruct) failed(reason string, err error) {
 	b.log.Warnf("ENTER FAILED STATE, due to %s: %v", reason, err)
 	BackendFailure.With(prometheus.Labels{"reason": reason}).Inc()
-	// lame duck mode.
-	UnregisterBackend(b.id)
+	b.progress <- progressCmd{"backend_failed", reason}
+	time.AfterFunc(failedBackendCooldown, func() {
+		recoverBackend(b.id)
+	})
 	for {
-		reply := <-b.getConn
-		reply <- nil
-	}
-}
-
-func dialSSH(info *configSSHTunnel, config *ssh.ClientConfig, proxyCommand string) (*ssh.Client, error) {
-	var conn net.Conn
-	var err error
-
-	if proxyCommand == "" {
-		conn, err = net.DialTimeout(`tcp`, info.Address, 10*time.Second)
-	} else {
-		conn, err = connectProxy(proxyCommand, info.Address)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	c, chans, reqs, err := ssh.NewClientConn(conn, info.Address, config)
-	if err != nil {
-		return nil, err
+		select {
+		case reply := <-b.getConn:
+			reply <- nil
+		case <-b.stop:
+			return
+		}
 	}
-	return ssh.NewClient(c, chans, reqs), nil
 }
 
 func (b *backendStruct) connectSSH() (client *ssh.Client, err error) {
 	start := time.Now()
 	b.progress <- progressCmd{"connection_start", nil}
 	b.log.Info("Connecting to SSH server")
-	for retry := 0; retry < maxRetriesServer; retry++ {
+	retry := newBackoff(b.info.SSHTunnel.ConnectRetry, defaultConnectRetry)
+	for {
 		b.progress <- progressCmd{"connection_try", nil}
-		client, err = dialSSH(b.info.SSHTunnel, b.sshConfig, proxyCommand)
-		if err == nil {
+		client, err = dialSSH(b.sshHops, b.dialFirstHop)
+		if isHostKeyMismatch(err) {
+			b.log.Warnf("SSH Connection failed: %v", err)
+			b.progress <- progressCmd{"host_key_mismatch", err.Error()}
+			return
+		} else if err == nil {
 			BackendConnectSSHDuration.Observe(time.Since(start).Seconds())
-			b.log.Infof("Connected to SSH server: %v, err %v", client, err)
-			go generateKeepalive(client)
+			b.logAuthentication()
 			b.progress <- progressCmd{"connection_established", nil}
 			return
 		}
 
-		b.log.Warnf("SSH Connection failed: %v - retrying", err)
-		b.progress <- progressCmd{"connection_retry", nil}
-		time.Sleep(1 * time.Second)
+		delay, ok := retry.next()
+		if !ok {
+			break
+		}
+		b.log.Warnf("SSH Connection failed: %v - retrying in %v", err, delay)
+		b.progress <- progressCmd{"connection_retry", retryFailure(err, retry.attempt, delay)}
+		if err = b.sleep(delay); err != nil {
+			return nil, err
+		}
 	}
 	b.log.Warnf("SSH Connection retry limit reached")
 	b.progress <- progressCmd{"connection_failed", "Connection retry limit reached"}
@@ -169,128 +261,139 @@ func (b *backendStruct) connectSSH() (client *ssh.Client, err error) {
 func (b *backendStruct) reconnectSSH() (client *ssh.Client, err error) {
 	b.progress <- progressCmd{"reconnection_start", nil}
 	b.log.Info("Re-connecting to SSH server")
-	client, err = dialSSH(b.info.SSHTunnel, b.sshConfig, proxyCommand)
-	if err == nil {
-		b.log.Infof("Re-connected to SSH server: %v, err %v", client, err)
-		BackendReconnectSSH.Inc()
-		go generateKeepalive(client)
-		b.progress <- progressCmd{"reconnection_established", nil}
-		return
+	retry := newBackoff(b.info.SSHTunnel.ReconnectRetry, defaultReconnectRetry)
+	for {
+		client, err = dialSSH(b.sshHops, b.dialFirstHop)
+		if err == nil {
+			b.log.Info("Re-connected to SSH server")
+			b.logAuthentication()
+			BackendReconnectSSH.Inc()
+			b.progress <- progressCmd{"reconnection_established", nil}
+			return
+		} else if isHostKeyMismatch(err) {
+			b.log.Warnf("SSH Re-connection failed: %v", err)
+			b.progress <- progressCmd{"host_key_mismatch", err.Error()}
+			return
+		}
+
+		delay, ok := retry.next()
+		if !ok {
+			break
+		}
+		b.log.Warnf("SSH Re-connection failed: %v - retrying in %v", err, delay)
+		b.progress <- progressCmd{"reconnection_retry", retryFailure(err, retry.attempt, delay)}
+		if err = b.sleep(delay); err != nil {
+			return nil, err
+		}
 	}
 
-	b.log.Warnf("SSH Re-connection failed. Assuming host is down.")
+	b.log.Warnf("SSH Re-connection failed: %v. Assuming host is down.", err)
 	b.progress <- progressCmd{"reconnection_failed", "Re-connection failed"}
 	return nil, err
 }
 
-func (b *backendStruct) bootstrap(client *ssh.Client) (err error) {
-	if len(b.info.SSHTunnel.Bootstrap) == 0 && b.info.SSHTunnel.Run == nil {
+func (b *backendStruct) prepareHop(server *configSSHServer) (hop sshHop, err error) {
+	hop.address = server.Address
+	hop.auth = &authLog{}
+	hop.dialTimeout = b.info.SSHTunnel.DialTimeout.or(defaultDialTimeout)
+	hop.handshakeTimeout = b.info.SSHTunnel.HandshakeTimeout.or(defaultHandshakeTimeout)
+	auth, err := b.authMethods(server, hop.auth)
+	if err != nil {
 		return
 	}
 
-	start := time.Now()
-
-	type BootstrapStep struct {
-		Description string `json:"description"`
-		Status      string `json:"status"`
+	hostKeyCallback, err := b.hostKeyCallback(server)
+	if err != nil {
+		return
 	}
-	status := struct {
-		Steps []BootstrapStep `json:"steps"`
-	}{make([]BootstrapStep, 0)}
 
-	for _, cmd := range b.info.SSHTunnel.Bootstrap {
-		status.Steps = append(status.Steps, BootstrapStep{cmd.Description, ""})
+	hop.config = &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: b.info.SSHTunnel.KeyExchanges,
+			Ciphers:      b.info.SSHTunnel.Ciphers,
+			MACs:         b.info.SSHTunnel.MACs,
+		},
+		User:              server.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: b.info.SSHTunnel.HostKeyAlgorithms,
 	}
+	return
+}
 
-	var session *ssh.Session
-	for idx, cmd := range b.info.SSHTunnel.Bootstrap {
-		b.log.Infof("Started running bootstrap '%s'", cmd.Command)
-		status.Steps[idx].Status = "started"
-		b.progress <- progressCmd{"bootstrap_status", status}
-		if session, err = client.NewSession(); err != nil {
+func (b *backendStruct) prepareSSH() (err error) {
+	if b.info.SSHTunnel.DialProxy != "" {
+		if _, err = parseDialProxy(b.info.SSHTunnel.DialProxy); err != nil {
+			b.progress <- progressCmd{"connection_failed", "Invalid SSH dial proxy"}
 			return
 		}
-		defer session.Close()
-		session.Stdout = os.Stdout
-		session.Stderr = os.Stderr
-		session.Run(cmd.Command)
-		status.Steps[idx].Status = "done"
-		b.progress <- progressCmd{"bootstrap_status", status}
-		b.log.Infof("Finished running bootstrap '%s'", cmd.Command)
 	}
 
-	if b.info.SSHTunnel.Run != nil {
-		b.log.Info("Running command: '%s'", b.info.SSHTunnel.Run.Command)
-		if session, err = client.NewSession(); err != nil {
-			return
-		}
-
-		modes := ssh.TerminalModes{
-			ssh.ECHO: 0,
-		}
+	servers := make([]*configSSHServer, 0)
+	for idx := range b.info.SSHTunnel.JumpHosts {
+		servers = append(servers, &b.info.SSHTunnel.JumpHosts[idx])
+	}
+	servers = append(servers, &b.info.SSHTunnel.configSSHServer)
 
-		if err = session.RequestPty("xterm", 80, 40, modes); err != nil {
-			b.log.Warnf("request for pseudo terminal failed: %s", err)
+	b.sshHops = nil
+	for _, server := range servers {
+		var hop sshHop
+		if hop, err = b.prepareHop(server); err != nil {
 			return
 		}
-
-		session.Start(b.info.SSHTunnel.Run.Command)
-		time.Sleep(500 * time.Millisecond)
+		b.sshHops = append(b.sshHops, hop)
 	}
-	BackendBootstrapDuration.Observe(time.Since(start).Seconds())
 	return
 }
 
-func acceptAllHostKeys(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	return nil
-}
+const unixAddressPrefix = "unix://"
 
-func (b *backendStruct) prepareSSH() (err error) {
-	sshKey := []byte(b.info.SSHTunnel.SSHKeyContents)
-	if b.info.SSHTunnel.SSHKeyFileName != "" {
-		sshKey, err = ioutil.ReadFile(b.info.SSHTunnel.SSHKeyFileName)
-		if err != nil {
-			b.progress <- progressCmd{"connection_failed", "Failed to read SSH key"}
-			return
-		}
+// Returns the network and address to dial for a backend address, which is either host:port
+// or the path of a Unix domain socket as unix:///path/to/socket.
+func backendDialAddress(address string) (network, addr string) {
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		return "unix", strings.TrimPrefix(address, unixAddressPrefix)
 	}
+	return "tcp", address
+}
 
-	key, err := ssh.ParsePrivateKey(sshKey)
-	if err != nil {
-		b.progress <- progressCmd{"connection_failed", "Failed to parse SSH key"}
-		return
+// Puts a connection request back in the queue, to be served later.
+func (b *backendStruct) putBack(reply chan net.Conn) {
+	select {
+	case b.getConn <- reply:
+	default:
+		reply <- nil
 	}
+}
 
-	b.sshConfig = &ssh.ClientConfig{
-		User: b.info.SSHTunnel.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(key),
-		},
-		HostKeyCallback: acceptAllHostKeys,
-	}
-	return
+// backendDialer opens connections to the backend application, which is either done through
+// an SSH client or directly by a net.Dialer.
+type backendDialer interface {
+	Dial(network, address string) (net.Conn, error)
 }
 
-func (b *backendStruct) connectionCreator(client *ssh.Client, onError chan error) {
-	putBack := func(reply chan net.Conn) {
+func (b *backendStruct) connectionCreator(client backendDialer, onError chan error, stop chan bool) {
+	for {
+		var reply chan net.Conn
 		select {
-		case b.getConn <- reply:
-		default:
-			reply <- nil
+		case reply = <-b.getConn:
+		case <-stop:
+			return
 		}
-	}
-
-	for {
-		reply := <-b.getConn
-		conn, err := client.Dial("tcp", b.info.Backend.Address)
+		conn, err := client.Dial(backendDialAddress(b.Address()))
 		if err != nil {
-			if err == io.EOF {
+			if _, tunneled := client.(*ssh.Client); !tunneled {
+				// The backend is down, and is waited for until it's ready again.
+				b.putBack(reply)
+				onError <- err
+				return
+			} else if err == io.EOF {
 				// Disconnected from the SSH server.
-				putBack(reply)
+				b.putBack(reply)
 				onError <- err
 				return
 			} else if err2, ok := err.(net.Error); ok && err2.Timeout() {
-				putBack(reply)
+				b.putBack(reply)
 				onError <- err2
 				return
 			} else {
@@ -301,27 +404,40 @@ func (b *backendStruct) connectionCreator(client *ssh.Client, onError chan error
 	}
 }
 
-func (b *backendStruct) waitBackend(client *ssh.Client) (err error) {
+func (b *backendStruct) waitBackend(client backendDialer) (err error) {
+	address := b.Address()
 	b.progress <- progressCmd{"waiting_backend", nil}
-	for retries := 0; retries < maxRetriesClient; retries++ {
+	retry := newBackoff(b.info.Backend.Retry, defaultBackendRetry)
+	for {
 		b.log.Info("Waiting for backend to be ready...")
 		var conn net.Conn
-		if conn, err = client.Dial("tcp", b.info.Backend.Address); err == nil {
+		if conn, err = client.Dial(backendDialAddress(address)); err == nil {
 			defer conn.Close()
 			b.log.Info("Backend is ready.")
 			b.progress <- progressCmd{"connection_success", nil}
 			return
-		} else if err == io.EOF {
-			b.log.Warnf("Disconnected from SSH server while connecting to %s: %v - re-connecting SSH", b.info.Backend.Address, err)
-			return
-		} else if err2, ok := err.(net.Error); ok && err2.Timeout() {
-			b.log.Warnf("Timeout connecting to %s: %v - re-connecting SSH", b.info.Backend.Address, err)
-			return
+		}
+		// Direct connections are retried until the backend is ready, while errors of the SSH
+		// connection make it re-connect.
+		if _, tunneled := client.(*ssh.Client); tunneled {
+			if err == io.EOF {
+				b.log.Warnf("Disconnected from SSH server while connecting to %s: %v - re-connecting SSH", address, err)
+				return
+			} else if err2, ok := err.(net.Error); ok && err2.Timeout() {
+				b.log.Warnf("Timeout connecting to %s: %v - re-connecting SSH", address, err)
+				return
+			}
 		}
 
 		b.log.Warnf("Backend not ready yet. (%v)", err)
+		delay, ok := retry.next()
+		if !ok {
+			break
+		}
 		b.progress <- progressCmd{"waiting_backend_retry", nil}
-		time.Sleep(5 * time.Second)
+		if err = b.sleep(delay); err != nil {
+			return
+		}
 	}
 	b.log.Warn("Waiting backend retry limit reached. Aborting.")
 	b.progress <- progressCmd{"waiting_backend_timeout", "Connection retry limit reached"}
@@ -329,61 +445,255 @@ func (b *backendStruct) waitBackend(client *ssh.Client) (err error) {
 	return
 }
 
-func (b *backendStruct) waitUntilStarted() {
-	<-b.start
+// Sleeps for the given duration, unless the backend is stopped before that.
+func (b *backendStruct) sleep(d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-b.stop:
+		return errBackendStopped
+	}
+}
+
+// Returns false if the backend was stopped before being started. Connection requests also
+// start the backend, as their Start call may have been dropped when the backend went idle.
+func (b *backendStruct) waitUntilStarted() bool {
+	select {
+	case <-b.start:
+	case reply := <-b.getConn:
+		b.putBack(reply)
+	case <-b.stop:
+		return false
+	}
 	BackendsStarted.Inc()
 	b.log.Info("Woke up")
-	// Just a goroutine that eats up all future start calls.
-	go func() {
-		for range b.start {
-		}
-	}()
+	return true
 }
 
 func (b *backendStruct) monitor() {
-	var client *ssh.Client
-	var err error
+	defer close(b.done)
+
+	for woken := false; ; woken = true {
+		// Don't connect until we get our initial connection attempt.
+		if !b.waitUntilStarted() {
+			return
+		}
+		if woken {
+			b.refreshInfo()
+		}
+
+		reason, err := b.run()
+		// A discovered address is only valid while the backend is running.
+		b.setAddress("")
+		if reason != "" {
+			b.failed(reason, err)
+			return
+		} else if err != errBackendIdle {
+			b.log.Info("Backend stopped")
+			return
+		}
 
-	// Don't connect until we get our initial connection attempt.
-	b.waitUntilStarted()
+		b.log.Infof("No traffic for %v - disconnecting until the next request", time.Duration(b.info.IdleTimeout))
+		BackendIdle.Inc()
+		b.isReady = false
+		b.progress <- progressCmd{"backend_idle", nil}
+		// Forget about requests that were made before going idle.
+		select {
+		case <-b.start:
+		default:
+		}
+	}
+}
+
+// Connects to the backend and serves connections until it's stopped or goes idle, in which
+// cases it returns an empty reason, or until it fails.
+func (b *backendStruct) run() (reason string, err error) {
+	var client *ssh.Client
+	var keepalives *keepalive
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+		if keepalives != nil {
+			// Make sure the old generator doesn't re-create the gauge after it's deleted.
+			keepalives.Stop()
+			BackendKeepaliveRTT.DeleteLabelValues(b.info.Host, b.info.Prefix)
+		}
+	}()
+	defer func() {
+		if err == errBackendStopped {
+			reason, err = "", nil
+		}
+	}()
+	defer func() {
+		// Whatever the reason for stopping, tear down what was started while the SSH server
+		// can still be reached.
+		if client != nil && b.sshAlive(client) {
+			b.teardown(client)
+		}
+	}()
 
 	if err = b.waitProvisioned(); err != nil {
-		b.failed("provisioning", err)
+		return "provisioning", err
 	}
 
-	b.log = b.log.WithFields(logrus.Fields{
-		"ssh_host": b.info.SSHTunnel.Address,
-		"backend":  b.info.Backend.Address,
-	})
+	if b.info.SSHTunnel == nil {
+		return b.runDirect()
+	}
 
 	if err = b.prepareSSH(); err != nil {
-		b.failed("prepare_ssh", err)
+		return "prepare_ssh", err
 	}
 
 	if client, err = b.connectSSH(); err != nil {
-		b.failed("connect_ssh", err)
+		if isHostKeyMismatch(err) {
+			return "host_key_mismatch", err
+		}
+		return "connect_ssh", err
 	}
+	keepalives = b.generateKeepalive(client)
 
 	if err = b.bootstrap(client); err != nil {
-		b.failed("bootstrap", err)
+		return "bootstrap", err
+	}
+
+	// The run command is either running or being restarted, as the backend fails otherwise.
+	var runExited <-chan runExit
+	var runRestarted <-chan runRestart
+	var cancelRestart chan bool
+	var restarts *backoff
+	if b.info.SSHTunnel.Run != nil {
+		if runExited, err = b.startRun(client); err != nil {
+			return "run", err
+		}
+	}
+	stopRestart := func() {
+		if cancelRestart != nil {
+			close(cancelRestart)
+			cancelRestart, runRestarted = nil, nil
+		}
+	}
+	handleRunExit := func(exit runExit) (err error) {
+		stopRestart()
+		runExited = nil
+		cancelRestart = make(chan bool)
+		runRestarted, err = b.superviseRun(client, exit, &restarts, cancelRestart)
+		return
+	}
+
+	if err = b.discoverAddress(client); err != nil {
+		return "discover_backend", err
 	}
 
 	if err = b.waitBackend(client); err != nil {
-		b.failed("wait_backend_ready", err)
+		return "wait_backend_ready", err
 	}
 	b.isReady = true
+	b.touch()
+
+	stopIdleWatch := make(chan bool)
+	defer close(stopIdleWatch)
+	idle := b.watchIdle(stopIdleWatch)
 
-	connectionError := make(chan error)
 	for {
-		go b.connectionCreator(client, connectionError)
-		err = <-connectionError
+		connectionError := make(chan error, 1)
+		stop := make(chan bool)
+		go b.connectionCreator(client, connectionError, stop)
+		select {
+		case err = <-connectionError:
+		case err = <-keepalives.err:
+		case <-idle:
+			close(stop)
+			stopRestart()
+			return "", errBackendIdle
+		case <-b.stop:
+			close(stop)
+			stopRestart()
+			return "", nil
+		case exit := <-runExited:
+			// The run command also ends when the SSH connection is lost, which is handled by
+			// re-connecting.
+			if _, _, err = client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+				close(stop)
+				if err = handleRunExit(exit); err != nil {
+					return "run_exited", err
+				}
+				continue
+			}
+		case restart := <-runRestarted:
+			cancelRestart, runRestarted = nil, nil
+			if err = restart.err; err == nil {
+				close(stop)
+				runExited = restart.exited
+				b.isReady = true
+				continue
+			} else if err == errBackendStopped {
+				close(stop)
+				return "run_exited", err
+			} else if _, _, aliveErr := client.SendRequest("keepalive@openssh.com", true, nil); aliveErr == nil {
+				close(stop)
+				return "run_exited", err
+			}
+		}
+		close(stop)
+		stopRestart()
+		keepalives.Stop()
+		client.Close()
 		b.log.Warnf("Connection error: %v - reconnecting", err)
 		if client, err = b.reconnectSSH(); err != nil {
-			b.failed("reconnect_ssh", err)
+			if isHostKeyMismatch(err) {
+				return "host_key_mismatch", err
+			}
+			return "reconnect_ssh", err
+		}
+		keepalives = b.generateKeepalive(client)
+
+		if b.info.SSHTunnel.Run != nil {
+			if err = handleRunExit(runExit{err: errors.New("Disconnected from SSH server")}); err != nil {
+				return "run_exited", err
+			}
 		}
 	}
 }
 
+// Serves connections to a backend that is dialed directly, instead of through an SSH tunnel.
+// Connection errors make it wait for the backend to be ready again, and it fails if the backend
+// isn't ready within its retry policy.
+func (b *backendStruct) runDirect() (reason string, err error) {
+	dialer := &net.Dialer{Timeout: defaultDialTimeout}
+	if err = b.waitBackend(dialer); err != nil {
+		return "wait_backend_ready", err
+	}
+	b.isReady = true
+	b.touch()
+
+	stopIdleWatch := make(chan bool)
+	defer close(stopIdleWatch)
+	idle := b.watchIdle(stopIdleWatch)
+
+	for {
+		connectionError := make(chan error, 1)
+		stop := make(chan bool)
+		go b.connectionCreator(dialer, connectionError, stop)
+		select {
+		case err = <-connectionError:
+		case <-idle:
+			close(stop)
+			return "", errBackendIdle
+		case <-b.stop:
+			close(stop)
+			return "", nil
+		}
+		close(stop)
+		b.isReady = false
+		b.log.Warnf("Connection error: %v - waiting for backend", err)
+		if err = b.waitBackend(dialer); err != nil {
+			return "wait_backend_ready", err
+		}
+		b.isReady = true
+	}
+}
+
 // NewBackend instantiates a new backend
 func NewBackend(id int, info PathInfo) Backend {
 	log := logrus.New().WithFields(logrus.Fields{
@@ -395,17 +705,19 @@ func NewBackend(id int, info PathInfo) Backend {
 	log.Logger = logrus.StandardLogger()
 
 	self := backendStruct{
-		id,
-		info,
-		log,
-		make(chan chan progressCmd),
-		make(chan chan net.Conn, 1000),
-		make(chan progressCmd),
-		make(chan bool),
-		false,
-		nil,
-	}
-	go progressBroker(self.progress, self.subscribeProgress)
+		id:                  id,
+		baseLog:             log,
+		subscribeProgress:   make(chan chan progressCmd),
+		unsubscribeProgress: make(chan chan progressCmd),
+		getConn:             make(chan chan net.Conn, 1000),
+		progress:            make(chan progressCmd),
+		start:               make(chan bool, 1),
+		stop:                make(chan bool),
+		done:                make(chan bool),
+		discoveredAddress:   make(chan string, 1),
+	}
+	self.setInfo(info)
+	go progressBroker(self.progress, self.subscribeProgress, self.unsubscribeProgress, self.done)
 	go self.monitor()
 
 	return &self
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionCreatorDirectDialError(t *testing.T) {
+	// Nothing listens on the address once the listener is closed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	b := &backendStruct{getConn: make(chan chan net.Conn), info: PathInfo{Backend: &configBackend{Address: address}}}
+	onError := make(chan error, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	go b.connectionCreator(&net.Dialer{Timeout: time.Second}, onError, stop)
+
+	reply := make(chan net.Conn, 1)
+	b.getConn <- reply
+	select {
+	case err = <-onError:
+		if err == nil {
+			t.Errorf("Refused dial reported a nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Refused dial wasn't reported")
+	}
+	if conn := <-reply; conn != nil {
+		t.Errorf("Request got connection %v, expected none", conn)
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const backendTLSHandshakeTimeout = 10 * time.Second
+
+// Returns PEM data that is either given inline or read from a file.
+func readPEM(contents, filename string) ([]byte, error) {
+	if filename != "" {
+		return ioutil.ReadFile(filename)
+	}
+	return []byte(contents), nil
+}
+
+// Creates the TLS config for connecting to the backend, or nil if it's reached over plain
+// HTTP.
+func newBackendTLSConfig(backend *configBackend, address string) (*tls.Config, error) {
+	switch backend.Scheme {
+	case "https":
+	case "", "http":
+		if backend.TLS == nil {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown backend scheme '%s'", backend.Scheme)
+	}
+
+	options := backend.TLS
+	if options == nil {
+		options = &configBackendTLS{}
+	}
+	config := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		if network, _ := backendDialAddress(address); network == "unix" {
+			config.ServerName = "localhost"
+		} else if config.ServerName, _, _ = net.SplitHostPort(address); config.ServerName == "" {
+			config.ServerName = "localhost"
+		}
+	}
+
+	if options.CABundle != "" || options.CABundleFileName != "" {
+		bundle, err := readPEM(options.CABundle, options.CABundleFileName)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("No certificates found in the CA bundle")
+		}
+	}
+
+	if options.ClientCertificate != "" || options.ClientCertificateFileName != "" {
+		cert, err := readPEM(options.ClientCertificate, options.ClientCertificateFileName)
+		if err != nil {
+			return nil, err
+		}
+		key, err := readPEM(options.ClientKey, options.ClientKeyFileName)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// TLSConfig returns the TLS config for connecting to the backend application, or nil if it
+// doesn't use TLS. It's created on first use, and again when the address or the path info
+// changes, e.g. after discovering the address.
+func (b *backendStruct) TLSConfig() (*tls.Config, error) {
+	info, address := b.GetInfo(), b.Address()
+	b.tlsLock.Lock()
+	defer b.tlsLock.Unlock()
+	if b.tlsAddress != address || b.tlsBackend != info.Backend {
+		b.tlsAddress, b.tlsBackend = address, info.Backend
+		b.tlsConfig, b.tlsErr = newBackendTLSConfig(info.Backend, address)
+		if b.tlsErr != nil {
+			b.GetLogger().Warnf("Invalid backend TLS configuration: %v", b.tlsErr)
+		}
+	}
+	return b.tlsConfig, b.tlsErr
+}
+
+// Starts a TLS session with the backend application on top of a connection to it. Connections
+// through the SSH tunnel don't support deadlines, so the connection is closed if the handshake
+// takes too long.
+func backendTLSClient(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	timer := time.AfterFunc(backendTLSHandshakeTimeout, func() {
+		conn.Close()
+	})
+	err := tlsConn.Handshake()
+	if !timer.Stop() {
+		return nil, errors.New("TLS handshake with the backend timed out")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
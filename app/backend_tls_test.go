@@ -0,0 +1,94 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificatePEM(t *testing.T) string {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestNewBackendTLSConfig(t *testing.T) {
+	ca := testCertificatePEM(t)
+	tests := []struct {
+		name       string
+		backend    configBackend
+		address    string
+		tls        bool
+		serverName string
+		rootCAs    bool
+		err        bool
+	}{
+		{"plain http", configBackend{}, "127.0.0.1:8080", false, "", false, false},
+		{"explicit http", configBackend{Scheme: "http"}, "127.0.0.1:8080", false, "", false, false},
+		{"https", configBackend{Scheme: "https"}, "app.internal:8443", true, "app.internal", false, false},
+		{"implied by options", configBackend{TLS: &configBackendTLS{}}, "app.internal:8443", true, "app.internal", false, false},
+		{"server name", configBackend{Scheme: "https", TLS: &configBackendTLS{ServerName: "app.example.com"}},
+			"127.0.0.1:8443", true, "app.example.com", false, false},
+		{"unix socket", configBackend{Scheme: "https"}, "unix:///run/app.sock", true, "localhost", false, false},
+		{"CA bundle", configBackend{Scheme: "https", TLS: &configBackendTLS{CABundle: ca}},
+			"app.internal:8443", true, "app.internal", true, false},
+		{"unknown scheme", configBackend{Scheme: "ftp"}, "127.0.0.1:21", false, "", false, true},
+		{"invalid CA bundle", configBackend{Scheme: "https", TLS: &configBackendTLS{CABundle: "not a certificate"}},
+			"app.internal:8443", false, "", false, true},
+		{"missing CA bundle file", configBackend{Scheme: "https", TLS: &configBackendTLS{CABundleFileName: "/nonexistent/ca.pem"}},
+			"app.internal:8443", false, "", false, true},
+		{"invalid client certificate", configBackend{Scheme: "https", TLS: &configBackendTLS{ClientCertificate: ca, ClientKey: "not a key"}},
+			"app.internal:8443", false, "", false, true},
+	}
+	for _, test := range tests {
+		config, err := newBackendTLSConfig(&test.backend, test.address)
+		if (err != nil) != test.err {
+			t.Errorf("%s: returned error %v", test.name, err)
+			continue
+		}
+		if (config != nil) != test.tls {
+			t.Errorf("%s: returned config %v, expected TLS %v", test.name, config, test.tls)
+			continue
+		}
+		if config == nil {
+			continue
+		}
+		if config.ServerName != test.serverName {
+			t.Errorf("%s: server name is %q, expected %q", test.name, config.ServerName, test.serverName)
+		}
+		if (config.RootCAs != nil) != test.rootCAs {
+			t.Errorf("%s: has root CAs %v, expected %v", test.name, config.RootCAs != nil, test.rootCAs)
+		}
+	}
+}
+
+func TestTLSConfigFollowsAddress(t *testing.T) {
+	b := &backendStruct{info: PathInfo{Backend: &configBackend{Scheme: "https", Address: "first.internal:443"}}}
+	config, err := b.TLSConfig()
+	if err != nil || config.ServerName != "first.internal" {
+		t.Fatalf("TLSConfig() = %v, %v, expected server name first.internal", config, err)
+	}
+	b.setAddress("second.internal:443")
+	if config, err = b.TLSConfig(); err != nil || config.ServerName != "second.internal" {
+		t.Errorf("TLSConfig() = %v, %v after discovery, expected server name second.internal", config, err)
+	}
+}
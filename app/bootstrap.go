@@ -0,0 +1,212 @@
+package app
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	onFailureAbort    = "abort"
+	onFailureContinue = "continue"
+	onFailureRetry    = "retry"
+)
+
+const bootstrapRetryDelay = 5 * time.Second
+
+// Number of stderr lines of a failed bootstrap command shown on the progress page
+const stderrTailLines = 20
+
+type bootstrapStep struct {
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Stderr      string `json:"stderr,omitempty"`
+}
+
+type bootstrapStatus struct {
+	Steps []bootstrapStep `json:"steps"`
+}
+
+// The status is copied when sent, as the progress broker holds on to it.
+func (s bootstrapStatus) copy() bootstrapStatus {
+	return bootstrapStatus{append([]bootstrapStep(nil), s.Steps...)}
+}
+
+// lineTail keeps the last lines that were added to it.
+type lineTail struct {
+	max   int
+	lines []string
+}
+
+func (t *lineTail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[1:]
+	}
+}
+
+func (t *lineTail) String() string {
+	return strings.Join(t.lines, "\n")
+}
+
+// Returns the exit code of a command that was run, or nil if it didn't exit by itself.
+func exitCode(err error) *int {
+	var code int
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		code = exitErr.ExitStatus()
+	} else if err != nil {
+		return nil
+	}
+	return &code
+}
+
+func (b *backendStruct) runBootstrapStep(client *ssh.Client, idx int, cmd configCommand, step *bootstrapStep) error {
+	output := newOutputStreamer(b.progress, idx)
+	stdout := &lineWriter{onLine: func(line string) {
+		b.log.Debugf("Bootstrap '%s': %s", cmd.Command, line)
+		if progress, ok := parseDirective(line); ok {
+			output.addDirective(progress)
+			return
+		}
+		output.add("stdout", line)
+	}}
+	stderrTail := &lineTail{max: stderrTailLines}
+	stderr := &lineWriter{onLine: func(line string) {
+		b.log.Debugf("Bootstrap '%s': %s", cmd.Command, line)
+		output.add("stderr", line)
+		stderrTail.add(line)
+	}}
+	rc, err := b.remoteCommand(cmd)
+	if err == nil {
+		rc.stop = b.stop
+		err = runCommand(client, rc, stdout, stderr)
+	}
+	stdout.Flush()
+	stderr.Flush()
+	output.Close()
+
+	if err == errBackendStopped {
+		return err
+	}
+	step.ExitCode = exitCode(err)
+	step.Stderr = stderrTail.String()
+	if step.ExitCode != nil && *step.ExitCode == cmd.ExpectedExitStatus {
+		step.Error = ""
+		return nil
+	} else if err == nil {
+		err = fmt.Errorf("Process exited with status 0, expected %d", cmd.ExpectedExitStatus)
+	}
+	step.Error = err.Error()
+	return err
+}
+
+// Runs the check command of a bootstrap step, returning true if it succeeds and the step can
+// be skipped.
+func (b *backendStruct) checkBootstrapStep(client *ssh.Client, cmd configCommand) bool {
+	check := cmd
+	check.Command = cmd.Check
+	rc, err := b.remoteCommand(check)
+	if err == nil {
+		rc.stop = b.stop
+		err = runCommand(client, rc, nil, nil)
+	}
+	if err != nil {
+		b.log.Debugf("Check '%s' of bootstrap '%s' failed: %v", cmd.Check, cmd.Command, err)
+	}
+	return err == nil
+}
+
+func (b *backendStruct) bootstrap(client *ssh.Client) (err error) {
+	if len(b.info.SSHTunnel.Uploads) == 0 && len(b.info.SSHTunnel.Bootstrap) == 0 {
+		return
+	}
+
+	start := time.Now()
+
+	status := bootstrapStatus{make([]bootstrapStep, 0)}
+	for _, upload := range b.info.SSHTunnel.Uploads {
+		description := upload.Description
+		if description == "" {
+			description = "Uploading " + path.Base(upload.Target)
+		}
+		status.Steps = append(status.Steps, bootstrapStep{Description: description})
+	}
+	for _, cmd := range b.info.SSHTunnel.Bootstrap {
+		switch cmd.OnFailure {
+		case "", onFailureAbort, onFailureContinue, onFailureRetry:
+		default:
+			b.progress <- progressCmd{"bootstrap_failed", "Unknown bootstrap failure policy"}
+			return fmt.Errorf("Unknown bootstrap failure policy '%s'", cmd.OnFailure)
+		}
+		status.Steps = append(status.Steps, bootstrapStep{Description: cmd.Description})
+	}
+
+	if len(b.info.SSHTunnel.Uploads) > 0 {
+		var sftpClient *sftp.Client
+		if sftpClient, err = sftp.NewClient(client); err != nil {
+			b.progress <- progressCmd{"bootstrap_failed", "Failed to start SFTP"}
+			return
+		}
+		err = b.uploadFiles(client, sftpClient, &status)
+		sftpClient.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	offset := len(b.info.SSHTunnel.Uploads)
+	for idx, cmd := range b.info.SSHTunnel.Bootstrap {
+		idx += offset
+		if cmd.Check != "" {
+			status.Steps[idx].Status = "checking"
+			b.progress <- progressCmd{"bootstrap_status", status.copy()}
+			if b.checkBootstrapStep(client, cmd) {
+				status.Steps[idx].Status = "skipped"
+				b.progress <- progressCmd{"bootstrap_status", status.copy()}
+				b.log.Infof("Skipped bootstrap '%s', as its check succeeded", cmd.Command)
+				continue
+			}
+		}
+
+		for attempt := 0; ; attempt++ {
+			b.log.Infof("Started running bootstrap '%s'", cmd.Command)
+			status.Steps[idx].Status = "started"
+			b.progress <- progressCmd{"bootstrap_status", status.copy()}
+
+			if err = b.runBootstrapStep(client, idx, cmd, &status.Steps[idx]); err == nil {
+				status.Steps[idx].Status = "done"
+				b.progress <- progressCmd{"bootstrap_status", status.copy()}
+				b.log.Infof("Finished running bootstrap '%s'", cmd.Command)
+				break
+			}
+
+			if err == errBackendStopped {
+				return
+			}
+			b.log.Warnf("Failed running bootstrap '%s': %v", cmd.Command, err)
+			status.Steps[idx].Status = "failed"
+			b.progress <- progressCmd{"bootstrap_status", status.copy()}
+
+			if cmd.OnFailure == onFailureRetry && attempt < cmd.Retries {
+				b.log.Infof("Retrying bootstrap '%s' in %v", cmd.Command, bootstrapRetryDelay)
+				if err = b.sleep(bootstrapRetryDelay); err != nil {
+					return
+				}
+				continue
+			} else if cmd.OnFailure == onFailureContinue {
+				err = nil
+				break
+			}
+			return fmt.Errorf("Bootstrap '%s' failed: %v", cmd.Command, err)
+		}
+	}
+
+	BackendBootstrapDuration.Observe(time.Since(start).Seconds())
+	return
+}
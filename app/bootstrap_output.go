@@ -0,0 +1,138 @@
+package app
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Output is sent to the progress page in batches, at most this often
+	bootstrapOutputInterval = 250 * time.Millisecond
+	// Longer lines are cut
+	bootstrapOutputMaxLine = 1024
+	// Output of a step beyond this size is dropped
+	bootstrapOutputMaxSize = 64 * 1024
+	// Progress directives of a step beyond this many bootstrap_progress events are dropped
+	bootstrapMaxProgressEvents = 500
+)
+
+type bootstrapOutputLine struct {
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+type bootstrapOutput struct {
+	Step      int                   `json:"step"`
+	Lines     []bootstrapOutputLine `json:"lines"`
+	Truncated bool                  `json:"truncated,omitempty"`
+}
+
+// outputStreamer sends the output of a bootstrap step to the progress page as
+// bootstrap_output events, and its progress directives as bootstrap_progress events. The
+// directives that arrive between two batches are coalesced into one event.
+type outputStreamer struct {
+	lock            sync.Mutex
+	progress        chan progressCmd
+	step            int
+	pending         []bootstrapOutputLine
+	size            int
+	truncated       bool
+	sent            bool
+	directive       bootstrapProgress
+	directiveUpdate bool
+	directivesSent  int
+	stop            chan bool
+	done            chan bool
+}
+
+func newOutputStreamer(progress chan progressCmd, step int) *outputStreamer {
+	s := &outputStreamer{
+		progress:  progress,
+		step:      step,
+		directive: bootstrapProgress{Step: step},
+		stop:      make(chan bool),
+		done:      make(chan bool),
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(bootstrapOutputInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush()
+			case <-s.stop:
+				s.flush()
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Cuts a line to at most max bytes, without splitting a multi-byte character.
+func truncateLine(line string, max int) string {
+	if len(line) <= max {
+		return line
+	}
+	for max > 0 && !utf8.RuneStart(line[max]) {
+		max--
+	}
+	return line[:max]
+}
+
+func (s *outputStreamer) add(stream, line string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	line = truncateLine(line, bootstrapOutputMaxLine)
+	if s.size+len(line) > bootstrapOutputMaxSize {
+		s.truncated = true
+		return
+	}
+	s.size += len(line)
+	s.pending = append(s.pending, bootstrapOutputLine{stream, line})
+}
+
+// Merges a progress directive into the step's progress, keeping what it doesn't update.
+func (s *outputStreamer) addDirective(progress bootstrapProgress) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if progress.Percent != nil {
+		s.directive.Percent = progress.Percent
+	}
+	if progress.Message != "" {
+		s.directive.Message = progress.Message
+	}
+	s.directiveUpdate = true
+}
+
+func (s *outputStreamer) flush() {
+	s.lock.Lock()
+	output := bootstrapOutput{s.step, s.pending, s.truncated && !s.sent}
+	s.pending = nil
+	if output.Truncated {
+		s.sent = true
+	}
+	directive, sendDirective := s.directive, s.directiveUpdate && s.directivesSent < bootstrapMaxProgressEvents
+	if sendDirective {
+		s.directiveUpdate = false
+		s.directivesSent++
+	}
+	s.lock.Unlock()
+
+	if len(output.Lines) > 0 || output.Truncated {
+		s.progress <- progressCmd{"bootstrap_output", output}
+	}
+	if sendDirective {
+		s.progress <- progressCmd{"bootstrap_progress", directive}
+	}
+}
+
+// Close sends what is left of the output.
+func (s *outputStreamer) Close() {
+	close(s.stop)
+	<-s.done
+}
@@ -0,0 +1,24 @@
+package app
+
+import "testing"
+
+func TestTruncateLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		max      int
+		expected string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本語", 4, "日"},
+		{"日本語", 2, ""},
+	}
+	for _, test := range tests {
+		if actual := truncateLine(test.line, test.max); actual != test.expected {
+			t.Errorf("truncateLine(%q, %d) = %q, expected %q", test.line, test.max, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExitCode(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		name     string
+		err      error
+		expected *int
+	}{
+		{"success", nil, &zero},
+		{"exit status", &ssh.ExitError{}, &zero},
+		{"timeout", errCommandTimeout, nil},
+		{"stopped", errBackendStopped, nil},
+		{"no exit status", &ssh.ExitMissingError{}, nil},
+		{"connection lost", errors.New("EOF"), nil},
+	}
+	for _, test := range tests {
+		actual := exitCode(test.err)
+		if (actual == nil) != (test.expected == nil) || (actual != nil && *actual != *test.expected) {
+			t.Errorf("%s: exitCode(%v) = %v, expected %v", test.name, test.err, actual, test.expected)
+		}
+	}
+}
+
+func TestLineTail(t *testing.T) {
+	tests := []struct {
+		max      int
+		lines    []string
+		expected string
+	}{
+		{3, nil, ""},
+		{3, []string{"a"}, "a"},
+		{3, []string{"a", "b", "c"}, "a\nb\nc"},
+		{3, []string{"a", "b", "c", "d", "e"}, "c\nd\ne"},
+		{1, []string{"a", "b"}, "b"},
+		{3, []string{"", "a", ""}, "\na\n"},
+	}
+	for _, test := range tests {
+		tail := &lineTail{max: test.max}
+		for _, line := range test.lines {
+			tail.add(line)
+		}
+		if actual := tail.String(); actual != test.expected {
+			t.Errorf("Tail of %d of %q = %q, expected %q", test.max, test.lines, actual, test.expected)
+		}
+	}
+}
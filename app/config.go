@@ -1,23 +1,237 @@
 package app
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// configDuration is a duration given either as a string, such as "1m30s", or as a number of seconds
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(buf []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(buf, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = configDuration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = configDuration(parsed)
+	default:
+		return fmt.Errorf("Invalid duration: %s", buf)
+	}
+	return nil
+}
+
+// Returns the duration, or fallback if it isn't set.
+func (d configDuration) or(fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+	return time.Duration(d)
+}
+
 type configCommand struct {
+	Description string         `json:"description"`
+	Command     string         `json:"command"`
+	Timeout     configDuration `json:"timeout"`
+	// A bootstrap command is skipped if its check command succeeds
+	Check string `json:"check"`
+	// The exit status of a successful bootstrap command, 0 by default
+	ExpectedExitStatus int `json:"expected_exit_status"`
+	// What to do when a bootstrap command fails: "abort" (default), "continue" or "retry"
+	OnFailure string `json:"on_failure"`
+	// Number of retries with the "retry" policy, before aborting
+	Retries int `json:"retries"`
+	// Environment variables of the command, in addition to those of the tunnel
+	Env map[string]string `json:"env"`
+	// Expand placeholders such as {{.Host}}, {{.Prefix}}, {{.BackendID}}, {{.BackendAddress}},
+	// {{.BasePath}}, {{.SSHAddress}}, {{.SSHUsername}} and {{.User}} (the basic auth user) in the
+	// command and the values of its environment, including that of the tunnel. Off by default, as
+	// commands may contain e.g. Go templates of their own.
+	Template bool `json:"template"`
+	// When to restart the run command after it exits: "never" (default), "on-failure" or
+	// "always". The backend fails when the command isn't restarted.
+	Restart string `json:"restart"`
+	// Delays between restarts of the run command. Defaults to an initial delay of 1 second,
+	// doubling up to 1 minute with 20% jitter, and a deadline of 15 minutes.
+	RestartRetry *configRetryPolicy `json:"restart_retry"`
+}
+
+type configRetryPolicy struct {
+	InitialDelay configDuration `json:"initial_delay"`
+	Multiplier   float64        `json:"multiplier"`
+	MaxDelay     configDuration `json:"max_delay"`
+	// Randomizes the delays by this fraction, e.g. 0.2 for +/- 20%. Set to 0 to disable.
+	Jitter *float64 `json:"jitter"`
+	// No new attempts are made after this time has passed since the first attempt
+	Deadline configDuration `json:"deadline"`
+}
+
+type configMintCertificate struct {
+	// Defaults to the username
+	Principals []string       `json:"principals"`
+	Validity   configDuration `json:"validity"`
+}
+
+type configKeyboardInteractive struct {
+	// Regular expression matched against the question asked by the server
+	Prompt     string `json:"prompt"`
+	Answer     string `json:"answer"`
+	AnswerFile string `json:"answer_file"`
+}
+
+// A file that is uploaded to the SSH server before the bootstrap commands are run
+type configUpload struct {
 	Description string `json:"description"`
-	Command     string `json:"command"`
+	// Base64 encoded contents
+	Contents string `json:"contents"`
+	// Local file that is uploaded
+	FileName string `json:"filename"`
+	// URL that is fetched and uploaded
+	URL string `json:"url"`
+	// Path on the SSH server
+	Target string `json:"target"`
+	// Expand placeholders in the target like in commands, e.g. {{.Host}}, {{.Prefix}} and
+	// {{.BackendID}}. Off by default.
+	Template bool `json:"template"`
+	// Octal file mode, "0644" by default
+	Mode string `json:"mode"`
 }
 
-type configSSHTunnel struct {
+// The address, credentials and host key verification of a SSH server
+type configSSHServer struct {
 	Address        string `json:"address"`
 	Username       string `json:"username"`
 	SSHKeyContents string `json:"ssh_key_contents"`
 	SSHKeyFileName string `json:"ssh_key_filename"`
+	// Passphrase of an encrypted SSH key, given inline, read from a file or taken from
+	// an environment variable.
+	SSHKeyPassphrase     string `json:"ssh_key_passphrase"`
+	SSHKeyPassphraseFile string `json:"ssh_key_passphrase_file"`
+	SSHKeyPassphraseEnv  string `json:"ssh_key_passphrase_env"`
+	// OpenSSH user certificate for the SSH key, given inline or read from a file
+	SSHCertificate         string `json:"ssh_certificate"`
+	SSHCertificateFileName string `json:"ssh_certificate_filename"`
+	// Sign a short-lived certificate for a newly generated key with the global CA key, for
+	// every connection.
+	MintCertificate *configMintCertificate `json:"mint_certificate"`
+	// Sign with the keys held by the ssh-agent
+	UseAgent bool `json:"use_agent"`
+	// Password, given inline or read from a file
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+	// Scripted answers for keyboard-interactive authentication
+	KeyboardInteractive []configKeyboardInteractive `json:"keyboard_interactive"`
+	// The order in which authentication methods ("key", "certificate", "agent", "password",
+	// "keyboard-interactive") are tried. Defaults to the configured methods in that order.
+	AuthMethods []string `json:"auth_methods"`
+
+	// Expected host key in authorized_keys format, and/or a list of accepted host key
+	// fingerprints (SHA256:... or legacy MD5). If neither is given, the global known_hosts
+	// file is used.
+	HostKey             string   `json:"host_key"`
+	HostKeyFingerprints []string `json:"host_key_fingerprints"`
+}
+
+type configSSHTunnel struct {
+	configSSHServer
+	// Bastion hosts that the SSH server is reached through, in order, like OpenSSH's ProxyJump
+	JumpHosts []configSSHServer `json:"jump_hosts"`
+	// SOCKS5 (socks5://) or HTTP CONNECT (http://) proxy that the first SSH server is dialed
+	// through, with optional credentials in the URL. Overrides the global proxy.
+	DialProxy string `json:"dial_proxy"`
+	// OpenSSH-style ProxyCommand for reaching the first SSH server, run through the shell. %h,
+	// %p and %r are replaced by the host, port and username. Overrides the global proxy.
+	ProxyCommand string `json:"proxy_command"`
+
+	// Defaults to 10 seconds, applies to the jump hosts as well
+	DialTimeout configDuration `json:"dial_timeout"`
+	// Defaults to 30 seconds, applies to the jump hosts as well
+	HandshakeTimeout configDuration `json:"handshake_timeout"`
+	// Keepalives are sent every interval (default 2 seconds). The connection is re-established
+	// when max_missed (default 3) of them in a row haven't been replied to within the interval,
+	// or within max_rtt if set.
+	KeepaliveInterval  configDuration `json:"keepalive_interval"`
+	KeepaliveMaxMissed int            `json:"keepalive_max_missed"`
+	KeepaliveMaxRTT    configDuration `json:"keepalive_max_rtt"`
 
+	// Retry policies when connecting and re-connecting to the SSH server. Unset fields default
+	// to an initial delay of 1 second, doubling up to 30 seconds with 20% jitter, and a deadline
+	// of 15 minutes (connect) or 5 minutes (re-connect).
+	ConnectRetry   *configRetryPolicy `json:"connect_retry"`
+	ReconnectRetry *configRetryPolicy `json:"reconnect_retry"`
+
+	// Allowed algorithms, in order of preference, for all hops. Defaults to the ones
+	// of golang.org/x/crypto/ssh.
+	KeyExchanges      []string `json:"key_exchanges"`
+	Ciphers           []string `json:"ciphers"`
+	MACs              []string `json:"macs"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms"`
+
+	// Uploaded over SFTP before the bootstrap commands are run
+	Uploads   []configUpload  `json:"uploads"`
 	Bootstrap []configCommand `json:"bootstrap"`
 	Run       *configCommand  `json:"run"`
+	// Run when the backend is unregistered, goes idle, fails or undergang shuts down, as long as
+	// the SSH server can still be reached
+	Teardown []configCommand `json:"teardown"`
+
+	// Environment variables of all commands. Values may contain placeholders for commands that
+	// enable templates.
+	Env map[string]string `json:"env"`
+	// Export the environment in the command when the SSH server doesn't accept it
+	EnvInlineFallback bool `json:"env_inline_fallback"`
+}
+
+type configDiscovery struct {
+	// Command on the SSH server that prints the address
+	Command string `json:"command"`
+	// Expand placeholders in the command, like for other commands
+	Template bool `json:"template"`
+	// File on the SSH server that contains the address
+	File string `json:"file"`
+	// Regular expression matched against the output of the run command, where the first group
+	// is the address
+	RunOutput string `json:"run_output"`
+	// How long to keep trying to discover the address, 5 minutes by default
+	Timeout configDuration `json:"timeout"`
+}
+
+type configBackendTLS struct {
+	// Name used to verify the backend's certificate, by default the host of the address
+	ServerName string `json:"server_name"`
+	// PEM encoded CA certificates to verify the backend's certificate with, instead of the
+	// system's
+	CABundle           string `json:"ca_bundle"`
+	CABundleFileName   string `json:"ca_bundle_filename"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// PEM encoded client certificate and key, for mutual TLS
+	ClientCertificate         string `json:"client_certificate"`
+	ClientCertificateFileName string `json:"client_certificate_filename"`
+	ClientKey                 string `json:"client_key"`
+	ClientKeyFileName         string `json:"client_key_filename"`
 }
 
 type configBackend struct {
+	// host:port, or unix:///path/to/socket for a Unix domain socket
 	Address  string `json:"address"`
 	BasePath string `json:"base_path"`
+	// Discover the address on the SSH server instead, e.g. when the application binds a random
+	// port. A discovered port without a host means localhost.
+	Discover *configDiscovery `json:"discover"`
+	// "http" (default) or "https", which is also implied by TLS options
+	Scheme string            `json:"scheme"`
+	TLS    *configBackendTLS `json:"tls"`
+	// Delays between attempts to connect while waiting for the backend to be ready, after which
+	// the backend fails. Defaults to every 5 seconds for up to 10 minutes.
+	Retry *configRetryPolicy `json:"retry"`
 }
 
 type configProvisioning struct {
@@ -59,6 +273,7 @@ type PathInfo struct {
 	Provisioning *configProvisioning `json:"provisioning"`
 	SSHTunnel    *configSSHTunnel    `json:"ssh_tunnel"`
 
+	// Dialed directly when there's no SSH tunnel
 	Backend         *configBackend    `json:"backend"`
 	StaticOverrides map[string]string `json:"static_overrides"`
 
@@ -67,4 +282,47 @@ type PathInfo struct {
 	BasicAuth *configBasicAuth `json:"basic_auth"`
 
 	ServerAuth *configServerAuth `json:"server_auth"`
+
+	// Disconnect from the backend when it hasn't had any traffic for this long. It's
+	// connected to again on the next request.
+	IdleTimeout configDuration `json:"idle_timeout"`
+}
+
+// Checks that a backend can be connected to with the path info. While it's being provisioned,
+// the rest of it may not be known yet.
+func (info PathInfo) validate() error {
+	if info.Provisioning != nil && info.Provisioning.Status == "started" {
+		return nil
+	}
+	if info.Backend == nil {
+		return fmt.Errorf("No backend configured for '%s%s'", info.Host, info.Prefix)
+	}
+	if err := info.Backend.Retry.validate("retry"); err != nil {
+		return err
+	}
+	if info.SSHTunnel != nil {
+		if err := info.SSHTunnel.ConnectRetry.validate("connect_retry"); err != nil {
+			return err
+		}
+		if err := info.SSHTunnel.ReconnectRetry.validate("reconnect_retry"); err != nil {
+			return err
+		}
+		if info.SSHTunnel.Run != nil {
+			if err := info.SSHTunnel.Run.RestartRetry.validate("restart_retry"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks a retry policy that may be unset. A multiplier below 1 would make the delays shrink.
+func (policy *configRetryPolicy) validate(name string) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		return fmt.Errorf("Invalid multiplier %v in %s - must be at least 1", policy.Multiplier, name)
+	}
+	return nil
 }
@@ -0,0 +1,29 @@
+package app
+
+import "testing"
+
+func TestPathInfoValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		info  PathInfo
+		valid bool
+	}{
+		{"direct", PathInfo{Backend: &configBackend{Address: "127.0.0.1:8080"}}, true},
+		{"tunnel", PathInfo{SSHTunnel: &configSSHTunnel{}, Backend: &configBackend{Address: "127.0.0.1:8080"}}, true},
+		{"no backend", PathInfo{}, false},
+		{"tunnel without backend", PathInfo{SSHTunnel: &configSSHTunnel{}}, false},
+		{"provisioning", PathInfo{Provisioning: &configProvisioning{Status: "started"}}, true},
+		{"provisioned without backend", PathInfo{Provisioning: &configProvisioning{Status: "done"}}, false},
+		{"default multiplier", PathInfo{SSHTunnel: &configSSHTunnel{ConnectRetry: &configRetryPolicy{}}, Backend: &configBackend{}}, true},
+		{"constant delay", PathInfo{SSHTunnel: &configSSHTunnel{ConnectRetry: &configRetryPolicy{Multiplier: 1}}, Backend: &configBackend{}}, true},
+		{"shrinking connect delay", PathInfo{SSHTunnel: &configSSHTunnel{ConnectRetry: &configRetryPolicy{Multiplier: 0.5}}, Backend: &configBackend{}}, false},
+		{"negative reconnect multiplier", PathInfo{SSHTunnel: &configSSHTunnel{ReconnectRetry: &configRetryPolicy{Multiplier: -2}}, Backend: &configBackend{}}, false},
+		{"shrinking backend retry delay", PathInfo{Backend: &configBackend{Retry: &configRetryPolicy{Multiplier: 0.5}}}, false},
+		{"shrinking restart delay", PathInfo{SSHTunnel: &configSSHTunnel{Run: &configCommand{RestartRetry: &configRetryPolicy{Multiplier: 0.9}}}, Backend: &configBackend{}}, false},
+	}
+	for _, test := range tests {
+		if err := test.info.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: validate() returned %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+var dialProxy string
+
+func parseDialProxy(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "http" {
+		return nil, fmt.Errorf("Unsupported proxy scheme '%s'", u.Scheme)
+	} else if u.Host == "" {
+		return nil, fmt.Errorf("No host in proxy URL '%s'", proxyURL)
+	}
+	return u, nil
+}
+
+// bufferedConn is a connection where some data has already been read into a buffer,
+// e.g. a SSH server banner that arrived together with the proxy's response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func dialHTTPConnect(u *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", u.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Host: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy refused to connect to %s: %s", address, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{conn, reader}, nil
+}
+
+func dialSOCKS5(u *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		// Older versions of golang.org/x/net only honor the forward dialer's timeout
+		return dialer.Dial("tcp", address)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return contextDialer.DialContext(ctx, "tcp", address)
+}
+
+// Connects to address through a SOCKS5 (socks5://) or HTTP CONNECT (http://) proxy. Proxy
+// credentials can be given in the URL.
+func dialThroughProxy(proxyURL, address string, timeout time.Duration) (net.Conn, error) {
+	u, err := parseDialProxy(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "socks5" {
+		return dialSOCKS5(u, address, timeout)
+	}
+	return dialHTTPConnect(u, address, timeout)
+}
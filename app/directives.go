@@ -0,0 +1,70 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Bootstrap commands can report their progress by printing directives on stdout:
+//
+//	::ug-progress percent=40 message=Pulling image 3/7
+//	::ug-step Migrating database
+//
+// The message takes up the rest of the line, so it must come last.
+const directivePrefix = "::ug-"
+
+type bootstrapProgress struct {
+	Step    int      `json:"step"`
+	Percent *float64 `json:"percent,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+// Parses a progress directive, returning false if the line isn't one.
+func parseDirective(line string) (progress bootstrapProgress, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, directivePrefix) {
+		return
+	}
+	var name, args string
+	if idx := strings.IndexByte(line, ' '); idx >= 0 {
+		name, args = line[len(directivePrefix):idx], strings.TrimSpace(line[idx+1:])
+	} else {
+		name = line[len(directivePrefix):]
+	}
+
+	switch name {
+	case "progress":
+	case "step":
+		if !strings.Contains(args, "=") {
+			progress.Message = args
+			return progress, true
+		}
+	default:
+		return
+	}
+
+	for args != "" {
+		var arg string
+		if strings.HasPrefix(args, "message=") {
+			arg, args = args, ""
+		} else if idx := strings.IndexByte(args, ' '); idx >= 0 {
+			arg, args = args[:idx], strings.TrimSpace(args[idx+1:])
+		} else {
+			arg, args = args, ""
+		}
+
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "percent":
+			if percent, err := strconv.ParseFloat(strings.TrimSuffix(kv[1], "%"), 64); err == nil && percent >= 0 && percent <= 100 {
+				progress.Percent = &percent
+			}
+		case "message":
+			progress.Message = kv[1]
+		}
+	}
+	return progress, true
+}
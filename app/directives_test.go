@@ -0,0 +1,76 @@
+package app
+
+import "testing"
+
+func TestParseDirective(t *testing.T) {
+	percent := func(p float64) *float64 {
+		return &p
+	}
+	tests := []struct {
+		line     string
+		ok       bool
+		expected bootstrapProgress
+	}{
+		{"Pulling image", false, bootstrapProgress{}},
+		{"::ug-unknown percent=40", false, bootstrapProgress{}},
+		{"echo ::ug-progress percent=40", false, bootstrapProgress{}},
+		{"::ug-progress percent=40", true, bootstrapProgress{Percent: percent(40)}},
+		{"  ::ug-progress percent=40%  ", true, bootstrapProgress{Percent: percent(40)}},
+		{"::ug-progress percent=12.5 message=Pulling image 3/7", true,
+			bootstrapProgress{Percent: percent(12.5), Message: "Pulling image 3/7"}},
+		{"::ug-progress message=a=b c", true, bootstrapProgress{Message: "a=b c"}},
+		{"::ug-progress percent=101", true, bootstrapProgress{}},
+		{"::ug-progress percent=-1", true, bootstrapProgress{}},
+		{"::ug-progress percent=lots", true, bootstrapProgress{}},
+		{"::ug-progress", true, bootstrapProgress{}},
+		{"::ug-step Migrating database", true, bootstrapProgress{Message: "Migrating database"}},
+		{"::ug-step percent=80 message=Migrating", true, bootstrapProgress{Percent: percent(80), Message: "Migrating"}},
+	}
+	for _, test := range tests {
+		actual, ok := parseDirective(test.line)
+		if ok != test.ok {
+			t.Errorf("parseDirective(%q) returned ok=%v, expected %v", test.line, ok, test.ok)
+			continue
+		}
+		if actual.Message != test.expected.Message ||
+			(actual.Percent == nil) != (test.expected.Percent == nil) ||
+			(actual.Percent != nil && *actual.Percent != *test.expected.Percent) {
+			t.Errorf("parseDirective(%q) = %+v, expected %+v", test.line, actual, test.expected)
+		}
+	}
+}
+
+func TestOutputStreamerCoalescesDirectives(t *testing.T) {
+	progress := make(chan progressCmd, bootstrapMaxProgressEvents+10)
+	s := newOutputStreamer(progress, 2)
+	fifty := 50.0
+	s.addDirective(bootstrapProgress{Percent: &fifty})
+	s.addDirective(bootstrapProgress{Message: "Almost there"})
+	s.Close()
+	close(progress)
+
+	var events []progressCmd
+	for msg := range progress {
+		events = append(events, msg)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Got %d events, expected 1", len(events))
+	}
+	p := events[0].Data.(bootstrapProgress)
+	if p.Step != 2 || p.Percent == nil || *p.Percent != 50 || p.Message != "Almost there" {
+		t.Errorf("Got %+v, expected step 2 at 50%% with the last message", p)
+	}
+}
+
+func TestOutputStreamerLimitsDirectives(t *testing.T) {
+	progress := make(chan progressCmd, bootstrapMaxProgressEvents+10)
+	s := newOutputStreamer(progress, 0)
+	for i := 0; i < bootstrapMaxProgressEvents+10; i++ {
+		s.addDirective(bootstrapProgress{Message: "Working"})
+		s.flush()
+	}
+	s.Close()
+	if len(progress) != bootstrapMaxProgressEvents {
+		t.Errorf("Got %d events, expected %d", len(progress), bootstrapMaxProgressEvents)
+	}
+}
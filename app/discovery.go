@@ -0,0 +1,162 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultDiscoveryTimeout = 5 * time.Minute
+
+const discoveryRetryDelay = 1 * time.Second
+
+// Address returns the address of the backend application, which is configured or discovered.
+func (b *backendStruct) Address() string {
+	b.addressLock.Lock()
+	defer b.addressLock.Unlock()
+	if b.address != "" {
+		return b.address
+	}
+	return b.GetInfo().Backend.Address
+}
+
+func (b *backendStruct) setAddress(address string) {
+	b.addressLock.Lock()
+	defer b.addressLock.Unlock()
+	b.address = address
+}
+
+// Turns what was discovered into an address, where only a port means localhost.
+func parseDiscoveredAddress(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, unixAddressPrefix) {
+		return s, nil
+	} else if _, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return net.JoinHostPort("localhost", s), nil
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// Returns a function that is called with every line of output of the run command, and reports
+// the addresses in lines that match the pattern.
+func (b *backendStruct) runOutputMatcher() (func(line string), error) {
+	discovery := b.info.Backend.Discover
+	if discovery == nil || discovery.RunOutput == "" {
+		return func(string) {}, nil
+	}
+	pattern, err := regexp.Compile(discovery.RunOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forget about what an earlier run command printed.
+	select {
+	case <-b.discoveredAddress:
+	default:
+	}
+	return func(line string) {
+		if match := pattern.FindStringSubmatch(line); len(match) > 1 {
+			select {
+			case b.discoveredAddress <- match[1]:
+			default:
+			}
+		}
+	}, nil
+}
+
+func (b *backendStruct) readDiscovery(client *ssh.Client) (string, error) {
+	discovery := b.info.Backend.Discover
+	if discovery.Command != "" {
+		rc, err := b.remoteCommand(configCommand{Command: discovery.Command, Template: discovery.Template})
+		if err != nil {
+			return "", err
+		}
+		rc.stop = b.stop
+		var output bytes.Buffer
+		if err = runCommand(client, rc, &output, nil); err != nil {
+			return "", err
+		}
+		return output.String(), nil
+	}
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return "", err
+	}
+	defer sftpClient.Close()
+	f, err := sftpClient.Open(discovery.File)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	return string(buf), err
+}
+
+func (b *backendStruct) discoveryFailed(err error) error {
+	b.log.Warnf("Failed to discover backend address: %v", err)
+	b.progress <- progressCmd{"discovery_failed", "Failed to discover backend address"}
+	return err
+}
+
+// Discovers the address of the backend application on the SSH server, if configured. It's
+// retried until the address is found, as the application may take a while to start.
+func (b *backendStruct) discoverAddress(client *ssh.Client) error {
+	discovery := b.info.Backend.Discover
+	if discovery == nil {
+		return nil
+	} else if discovery.RunOutput != "" && b.info.SSHTunnel.Run == nil {
+		return b.discoveryFailed(errors.New("Discovery from run output, but no run command"))
+	} else if discovery.RunOutput == "" && discovery.Command == "" && discovery.File == "" {
+		return b.discoveryFailed(errors.New("No discovery method configured"))
+	}
+
+	b.progress <- progressCmd{"discovering_backend", nil}
+	b.log.Info("Discovering backend address")
+	timeout := time.After(discovery.Timeout.or(defaultDiscoveryTimeout))
+	for {
+		var found string
+		var err error
+		if discovery.RunOutput != "" {
+			select {
+			case found = <-b.discoveredAddress:
+			case <-timeout:
+				return b.discoveryFailed(errors.New("No matching output from the run command"))
+			case <-b.stop:
+				return errBackendStopped
+			}
+		} else {
+			found, err = b.readDiscovery(client)
+		}
+
+		if err == nil {
+			var address string
+			if address, err = parseDiscoveredAddress(found); err == nil {
+				b.setAddress(address)
+				b.log.Infof("Discovered backend address %s", address)
+				b.progress <- progressCmd{"backend_discovered", address}
+				return nil
+			}
+		}
+
+		b.log.Debugf("Backend address not discovered yet: %v", err)
+		select {
+		case <-timeout:
+			return b.discoveryFailed(err)
+		case <-time.After(discoveryRetryDelay):
+		case <-b.stop:
+			return errBackendStopped
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package app
+
+import "testing"
+
+func TestParseDiscoveredAddress(t *testing.T) {
+	tests := []struct {
+		discovered string
+		expected   string
+		err        bool
+	}{
+		{"8080", "localhost:8080", false},
+		{" 8080\n", "localhost:8080", false},
+		{"127.0.0.1:8080", "127.0.0.1:8080", false},
+		{"[::1]:8080", "[::1]:8080", false},
+		{"app.internal:80\n", "app.internal:80", false},
+		{"unix:///run/app.sock", "unix:///run/app.sock", false},
+		{"65536", "", true},
+		{"localhost", "", true},
+		{"", "", true},
+		{"port 8080", "", true},
+	}
+	for _, test := range tests {
+		actual, err := parseDiscoveredAddress(test.discovered)
+		if (err != nil) != test.err {
+			t.Errorf("parseDiscoveredAddress(%q) returned error %v", test.discovered, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("parseDiscoveredAddress(%q) = %q, expected %q", test.discovered, actual, test.expected)
+		}
+	}
+}
@@ -47,6 +47,10 @@ func doLookup(host string, path string) *PathInfo {
 
 	var info PathInfo
 	ret.Body.FromJsonTo(&info)
+	if err := info.validate(); err != nil {
+		log.Warnf("External lookup returned an invalid path info: %v", err)
+		return nil
+	}
 	return &info
 }
 
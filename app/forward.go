@@ -94,10 +94,31 @@ func forward(w http.ResponseWriter, req *http.Request) {
 	}
 	defer conn.Close()
 
+	tlsConfig, err := backend.TLSConfig()
+	if err != nil {
+		respond(log, w, req, "Invalid backend TLS configuration", http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	dial := func(network, addr string) (net.Conn, error) {
+		return conn, nil
+	}
+	if tlsConfig != nil {
+		// TLS is layered on top of the connection through the tunnel.
+		scheme = "https"
+		dial = func(network, addr string) (net.Conn, error) {
+			return backendTLSClient(conn, tlsConfig)
+		}
+	}
+
 	director := func(req *http.Request) {
 		req.URL.Path = backend.GetInfo().Backend.BasePath + strings.TrimPrefix(req.URL.Path, backend.GetInfo().Prefix)
-		req.URL.Scheme = "http"
-		req.URL.Host = backend.GetInfo().Backend.Address
+		req.URL.Scheme = scheme
+		req.URL.Host = backend.Address()
+		if network, _ := backendDialAddress(req.URL.Host); network == "unix" {
+			req.URL.Host = "localhost"
+		}
 	}
 
 	var revProxy http.Handler
@@ -105,18 +126,15 @@ func forward(w http.ResponseWriter, req *http.Request) {
 		revProxy = &websocketReverseProxy{
 			Backend:  backend,
 			Director: director,
-			Dial: func(network, addr string) (net.Conn, error) {
-				return conn, nil
-			},
+			Dial:     dial,
 		}
 
 	} else {
 		revProxy = &httputil.ReverseProxy{
 			Director: director,
 			Transport: &http.Transport{
-				Dial: func(network, addr string) (net.Conn, error) {
-					return conn, nil
-				},
+				Dial:    dial,
+				DialTLS: dial,
 			},
 		}
 	}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var knownHostsFile string
+var knownHostsTOFU bool
+var acceptAnyHostKey bool
+
+// Serializes reads and trust-on-first-use writes of the known_hosts file.
+var knownHostsLock sync.Mutex
+
+type hostKeyMismatchError struct {
+	hostname    string
+	fingerprint string
+	unknown     bool
+}
+
+func (e *hostKeyMismatchError) Error() string {
+	if e.unknown {
+		return fmt.Sprintf("Unknown host key %s for %s", e.fingerprint, e.hostname)
+	}
+	return fmt.Sprintf("Host key mismatch for %s: got %s", e.hostname, e.fingerprint)
+}
+
+// Newer versions of golang.org/x/crypto wrap the errors of host key callbacks.
+func isHostKeyMismatch(err error) bool {
+	for err != nil {
+		if _, ok := err.(*hostKeyMismatchError); ok {
+			return true
+		}
+		wrapper, ok := err.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+func acceptAllHostKeys(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	return nil
+}
+
+// Verifies the key against the pinned host key and fingerprints of the server.
+// Fingerprints can be given both in SHA256 and in legacy MD5 format.
+func pinnedHostKeyCallback(pinned ssh.PublicKey, fingerprints []string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if pinned != nil && bytes.Equal(pinned.Marshal(), key.Marshal()) {
+			return nil
+		}
+		for _, fingerprint := range fingerprints {
+			if fingerprint == ssh.FingerprintSHA256(key) || fingerprint == ssh.FingerprintLegacyMD5(key) {
+				return nil
+			}
+		}
+		return &hostKeyMismatchError{hostname, ssh.FingerprintSHA256(key), false}
+	}
+}
+
+func trustHostKey(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// The known_hosts file is re-read on every verification, as it may have been updated by
+// trust-on-first-use or by an external process.
+func knownHostsCallback(log *logrus.Entry) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+
+		var err error
+		if _, statErr := os.Stat(knownHostsFile); os.IsNotExist(statErr) && knownHostsTOFU {
+			err = &knownhosts.KeyError{}
+		} else {
+			var callback ssh.HostKeyCallback
+			if callback, err = knownhosts.New(knownHostsFile); err != nil {
+				return err
+			}
+			err = callback(hostname, remote, key)
+		}
+
+		if keyErr, ok := err.(*knownhosts.KeyError); ok {
+			if len(keyErr.Want) == 0 && knownHostsTOFU {
+				log.Infof("Trusting new host key %s for %s", ssh.FingerprintSHA256(key), hostname)
+				return trustHostKey(hostname, key)
+			}
+			return &hostKeyMismatchError{hostname, ssh.FingerprintSHA256(key), len(keyErr.Want) == 0}
+		}
+		return err
+	}
+}
+
+func (b *backendStruct) hostKeyCallback(server *configSSHServer) (ssh.HostKeyCallback, error) {
+	if server.HostKey != "" || len(server.HostKeyFingerprints) > 0 {
+		var pinned ssh.PublicKey
+		if server.HostKey != "" {
+			var err error
+			if pinned, _, _, _, err = ssh.ParseAuthorizedKey([]byte(server.HostKey)); err != nil {
+				b.progress <- progressCmd{"connection_failed", "Failed to parse SSH host key"}
+				return nil, err
+			}
+		}
+		return pinnedHostKeyCallback(pinned, server.HostKeyFingerprints), nil
+	}
+
+	if knownHostsFile != "" {
+		return knownHostsCallback(b.log), nil
+	}
+
+	if acceptAnyHostKey {
+		b.log.Warn("No host key verification configured - accepting all host keys")
+		return acceptAllHostKeys, nil
+	}
+	b.progress <- progressCmd{"connection_failed", "No SSH host key verification configured"}
+	return nil, fmt.Errorf("No host key or known_hosts file configured for %s", server.Address)
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestIsHostKeyMismatch(t *testing.T) {
+	mismatch := &hostKeyMismatchError{"example.com", "SHA256:abc", false}
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{mismatch, true},
+		{fmt.Errorf("ssh: handshake failed: %w", mismatch), true},
+		{fmt.Errorf("ssh: handshake failed: %v", mismatch), false},
+		{fmt.Errorf("ssh: handshake failed: EOF"), false},
+	}
+	for _, test := range tests {
+		if actual := isHostKeyMismatch(test.err); actual != test.expected {
+			t.Errorf("isHostKeyMismatch(%v) = %v, expected %v", test.err, actual, test.expected)
+		}
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	key, other := testHostKey(t), testHostKey(t)
+	defer func(file string, acceptAny bool) {
+		knownHostsFile, acceptAnyHostKey = file, acceptAny
+	}(knownHostsFile, acceptAnyHostKey)
+	knownHostsFile = ""
+
+	tests := []struct {
+		name      string
+		server    configSSHServer
+		acceptAny bool
+		err       bool
+		accepts   bool
+	}{
+		{"pinned key", configSSHServer{HostKey: string(ssh.MarshalAuthorizedKey(key))}, false, false, true},
+		{"other pinned key", configSSHServer{HostKey: string(ssh.MarshalAuthorizedKey(other))}, false, false, false},
+		{"fingerprint", configSSHServer{HostKeyFingerprints: []string{ssh.FingerprintSHA256(key)}}, false, false, true},
+		{"invalid pinned key", configSSHServer{HostKey: "ssh-ed25519 garbage"}, false, true, false},
+		{"no verification", configSSHServer{}, false, true, false},
+		{"accept any host key", configSSHServer{}, true, false, true},
+	}
+	for _, test := range tests {
+		acceptAnyHostKey = test.acceptAny
+		b := &backendStruct{log: logrus.NewEntry(logrus.New()), progress: make(chan progressCmd, 1)}
+		callback, err := b.hostKeyCallback(&test.server)
+		if (err != nil) != test.err {
+			t.Errorf("%s: returned error %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			if len(b.progress) != 1 {
+				t.Errorf("%s: didn't report the failure on the progress page", test.name)
+			}
+			continue
+		}
+		if accepted := callback("example.com:22", nil, key) == nil; accepted != test.accepts {
+			t.Errorf("%s: accepted the host key %v, expected %v", test.name, accepted, test.accepts)
+		}
+	}
+}
@@ -5,14 +5,48 @@ var script = `
     if (window["WebSocket"]) {
         var wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
         conn = new WebSocket(wsProtocol + "//" + window.location.hostname + ":" + window.location.port + window.location.pathname.substring(0, window.location.pathname.lastIndexOf('/')) + "/__undergang_02648018bfd74fa5a4ed50db9bb07859_ws");
+        // Set when the backend has failed or gone idle, after which the connection is closed once
+        // the backend is restarted. Reloading then shows its new progress.
+        var finished = false;
         conn.onclose = function(evt) {
-		console.log("Connection closed");
+            console.log("Connection closed");
+            if (finished) {
+                setTimeout(function() { window.location.reload(false); }, 2000);
+            }
         }
         conn.onmessage = function(evt) {
             console.log(evt)
             var payload = JSON.parse(evt.data)
-            if (payload.kind == "connection_success") {
+            if (payload.kind == "backend_failed" || payload.kind == "backend_idle") {
+                finished = true;
+            } else if (payload.kind == "connection_success") {
                 window.location.reload(false);
+            } else if (payload.kind == "bootstrap_progress") {
+                var progress = document.getElementById("progress");
+                if (progress) {
+                    progress.style.display = "block";
+                    if (payload.data.percent !== undefined) {
+                        document.getElementById("progress-bar").style.width = payload.data.percent + "%";
+                    }
+                    if (payload.data.message !== undefined) {
+                        document.getElementById("progress-message").textContent = payload.data.message;
+                    }
+                }
+            } else if (payload.kind == "bootstrap_output") {
+                var output = document.getElementById("console-output");
+                if (output) {
+                    payload.data.lines.forEach(function(line) {
+                        var span = document.createElement("span");
+                        span.className = line.stream;
+                        span.textContent = line.text + "\n";
+                        output.appendChild(span);
+                    });
+                    if (payload.data.truncated) {
+                        output.appendChild(document.createTextNode("[output truncated]\n"));
+                    }
+                    document.getElementById("console").style.display = "block";
+                    output.scrollTop = output.scrollHeight;
+                }
             }
         }
     } else {
@@ -197,6 +231,48 @@ h1 {
 	color: white;
     overflow: auto;
 }
+
+#progress {
+  display: none;
+  width: 80%;
+  margin: 20px auto;
+  font-family: 'Lato';
+  color: white;
+  text-align: center;
+}
+
+#progress-track {
+  height: 6px;
+  background-color: rgba(0, 0, 0, 0.3);
+}
+
+#progress-bar {
+  width: 0;
+  height: 100%;
+  background: white;
+  transition: width 0.5s;
+}
+
+#console {
+  display: none;
+  width: 80%;
+  margin: 40px auto;
+  font-family: 'Lato';
+  color: white;
+}
+
+#console-output {
+  max-height: 300px;
+  overflow: auto;
+  padding: 10px;
+  font-size: 0.8em;
+  white-space: pre-wrap;
+  background-color: rgba(0, 0, 0, 0.3);
+}
+
+#console-output .stderr {
+  color: #ffd0d0;
+}
 </style>
 </head>
 <body>
@@ -213,6 +289,16 @@ h1 {
 
 </div>
 
+<div id="progress">
+  <div id="progress-track"><div id="progress-bar"></div></div>
+  <p id="progress-message"></p>
+</div>
+
 <div id="log"></div>
+
+<details id="console">
+  <summary>Output</summary>
+  <pre id="console-output"></pre>
+</details>
 </body>
 </html>`
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errBackendIdle = errors.New("Backend idle")
+
+// trackedConn keeps count of the backend's open connections, as a backend isn't idle
+// while e.g. a websocket is open.
+type trackedConn struct {
+	net.Conn
+	backend   *backendStruct
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt32(&c.backend.activeConns, -1)
+		c.backend.touch()
+	})
+	return c.Conn.Close()
+}
+
+func (b *backendStruct) trackConn(conn net.Conn) net.Conn {
+	atomic.AddInt32(&b.activeConns, 1)
+	return &trackedConn{Conn: conn, backend: b}
+}
+
+func (b *backendStruct) touch() {
+	atomic.StoreInt64(&b.lastActivity, time.Now().UnixNano())
+}
+
+func (b *backendStruct) isIdle() bool {
+	if atomic.LoadInt32(&b.activeConns) > 0 {
+		return false
+	}
+	lastActivity := time.Unix(0, atomic.LoadInt64(&b.lastActivity))
+	return time.Since(lastActivity) > time.Duration(b.info.IdleTimeout)
+}
+
+// Returns a channel that is closed once the backend has gone idle, or nil if the backend
+// should never go idle. Watching stops when the stop channel is closed.
+func (b *backendStruct) watchIdle(stop chan bool) <-chan bool {
+	if b.info.IdleTimeout <= 0 {
+		return nil
+	}
+	interval := time.Duration(b.info.IdleTimeout) / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	idle := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if b.isIdle() {
+					close(idle)
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return idle
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -12,6 +13,31 @@ import (
 var externalLookupURL string
 var proxyCommand string
 var undergangVersion string
+var failedBackendCooldown time.Duration
+
+// Options contains the global settings of the application
+type Options struct {
+	// URL for the external pathinfo service
+	PathInfoURL string
+	// Optional utility for proxying SSH connections
+	ProxyCommand string
+	// Optional SOCKS5 or HTTP CONNECT proxy URL for SSH connections
+	DialProxy string
+	// OpenSSH known_hosts file used to verify host keys
+	KnownHostsFile string
+	// If set, unknown host keys are trusted and added to KnownHostsFile
+	KnownHostsTOFU bool
+	// If set, host keys aren't verified for servers without a pinned key, when there's no
+	// KnownHostsFile
+	InsecureAcceptAnyHostKey bool
+	// Socket of the ssh-agent used by tunnels with agent authentication
+	SSHAuthSock string
+	// CA key used to mint short-lived SSH certificates
+	SSHCAKeyFile string
+	// Time before a failed backend is replaced by a new one
+	FailedBackendCooldown time.Duration
+	Version               string
+}
 
 func dumpHandler(w http.ResponseWriter, req *http.Request) {
 	buf := make([]byte, 1<<20)
@@ -28,11 +54,24 @@ func versionHandler(w http.ResponseWriter, req *http.Request) {
 	io.WriteString(w, undergangVersion+"\n")
 }
 
-// Init initializes the application
-func Init(extPathLookupURL, proxyCmd, version string) {
-	proxyCommand = proxyCmd
-	externalLookupURL = extPathLookupURL
-	undergangVersion = version
+// Init initializes the application, after validating the options
+func Init(options Options) error {
+	if options.DialProxy != "" {
+		if _, err := parseDialProxy(options.DialProxy); err != nil {
+			return err
+		}
+	}
+
+	proxyCommand = options.ProxyCommand
+	dialProxy = options.DialProxy
+	externalLookupURL = options.PathInfoURL
+	knownHostsFile = options.KnownHostsFile
+	knownHostsTOFU = options.KnownHostsTOFU
+	acceptAnyHostKey = options.InsecureAcceptAnyHostKey
+	sshAuthSock = options.SSHAuthSock
+	sshCAKeyFile = options.SSHCAKeyFile
+	failedBackendCooldown = options.FailedBackendCooldown
+	undergangVersion = options.Version
 	go backendManager()
 
 	http.HandleFunc("/__ug__dump", dumpHandler)
@@ -40,4 +79,5 @@ func Init(extPathLookupURL, proxyCmd, version string) {
 	http.HandleFunc("/__ug__version", versionHandler)
 	http.Handle("/__ug__metrics", promhttp.Handler())
 	http.HandleFunc("/", forward)
+	return nil
 }
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultKeepaliveInterval = 2 * time.Second
+const defaultKeepaliveMaxMissed = 3
+
+// A running keepalive generator. Stop must be called before dropping it, so that it's known
+// that onRTT won't be called anymore.
+type keepalive struct {
+	err      <-chan error
+	stop     chan bool
+	stopOnce sync.Once
+	done     chan bool
+}
+
+// Stop stops sending keepalives and waits for the generator to exit
+func (k *keepalive) Stop() {
+	k.stopOnce.Do(func() { close(k.stop) })
+	<-k.done
+}
+
+// Sends keepalives every interval. Keepalives that aren't replied to within the interval, or
+// slower than maxRTT if set, are missed. When maxMissed are missed in a row, or the connection
+// fails, the client is closed and the error is sent on the err channel.
+func generateKeepalive(client *ssh.Client, interval, maxRTT time.Duration, maxMissed int, onRTT func(time.Duration)) *keepalive {
+	onError := make(chan error, 1)
+	k := &keepalive{err: onError, stop: make(chan bool), done: make(chan bool)}
+	go func() {
+		defer close(k.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		missed := 0
+		for {
+			select {
+			case <-t.C:
+			case <-k.stop:
+				return
+			}
+			start := time.Now()
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := client.Conn.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+
+			select {
+			case err := <-reply:
+				if err != nil {
+					client.Close()
+					onError <- err
+					return
+				}
+				rtt := time.Since(start)
+				onRTT(rtt)
+				if maxRTT > 0 && rtt > maxRTT {
+					missed++
+				} else {
+					missed = 0
+				}
+			case <-time.After(interval):
+				missed++
+			case <-k.stop:
+				return
+			}
+
+			if missed >= maxMissed {
+				client.Close()
+				onError <- errors.New("Too many missed SSH keepalives")
+				return
+			}
+		}
+	}()
+	return k
+}
+
+func (b *backendStruct) generateKeepalive(client *ssh.Client) *keepalive {
+	maxMissed := b.info.SSHTunnel.KeepaliveMaxMissed
+	if maxMissed <= 0 {
+		maxMissed = defaultKeepaliveMaxMissed
+	}
+	rtt := BackendKeepaliveRTT.With(prometheus.Labels{"host": b.info.Host, "prefix": b.info.Prefix})
+	return generateKeepalive(client,
+		b.info.SSHTunnel.KeepaliveInterval.or(defaultKeepaliveInterval),
+		time.Duration(b.info.SSHTunnel.KeepaliveMaxRTT),
+		maxMissed,
+		func(d time.Duration) {
+			rtt.Set(d.Seconds())
+		})
+}
+
+// Checks that the SSH server still replies to keepalives.
+func (b *backendStruct) sshAlive(client *ssh.Client) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		reply <- err
+	}()
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(b.info.SSHTunnel.KeepaliveInterval.or(defaultKeepaliveInterval)):
+		return false
+	}
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+)
+
+// lineWriter is an io.Writer that calls onLine for every complete line written to it.
+type lineWriter struct {
+	onLine func(line string)
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.onLine(strings.TrimRight(string(w.buf[:idx]), "\r"))
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush calls onLine with any trailing partial line.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.onLine(strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -19,6 +20,8 @@ type lookupReq struct {
 
 type unregisterReq struct {
 	id int
+	// Replace the backend with a new one, if it was added with AddPath
+	recreate bool
 }
 
 type mappingkey struct {
@@ -29,12 +32,13 @@ type mappingkey struct {
 var addPathChan = make(chan addPathReq)
 var lookupChan = make(chan lookupReq)
 var unregisterChan = make(chan unregisterReq)
+var shutdownChan = make(chan chan []Backend)
 
 // AddPath adds a backend to the manager
-func AddPath(info PathInfo) {
+func AddPath(info PathInfo) error {
 	reply := make(chan error)
 	addPathChan <- addPathReq{info, reply}
-	<-reply
+	return <-reply
 }
 
 // LookupBackend looks up a backend given a host and path
@@ -44,9 +48,36 @@ func LookupBackend(host, path string) Backend {
 	return <-reply
 }
 
-// UnregisterBackend unregisters a backend from the manager
+// UnregisterBackend unregisters a backend from the manager and stops it
 func UnregisterBackend(id int) {
-	unregisterChan <- unregisterReq{id}
+	unregisterChan <- unregisterReq{id, false}
+}
+
+// Shutdown stops all backends and waits for them to be torn down, or until the context is done
+func Shutdown(ctx context.Context) error {
+	reply := make(chan []Backend)
+	shutdownChan <- reply
+	backends := <-reply
+
+	done := make(chan bool)
+	go func() {
+		for _, backend := range backends {
+			backend.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stops a failed backend. Backends that were added with AddPath are replaced by new ones, and
+// others will be looked up again on the next request.
+func recoverBackend(id int) {
+	unregisterChan <- unregisterReq{id, true}
 }
 
 func lookupPath(mapping map[mappingkey]Backend, host, path string) Backend {
@@ -83,6 +114,7 @@ func backendManager() {
 	log.Logger = logrus.StandardLogger()
 
 	mapping := make(map[mappingkey]Backend)
+	staticPaths := make(map[mappingkey]PathInfo)
 	externalLookupReq := make(chan lookupReq, 100)
 	externalLookupResp := make(chan externalLookupResp, 100)
 
@@ -113,6 +145,11 @@ func backendManager() {
 	for {
 		select {
 		case req := <-addPathChan:
+			if err := req.info.validate(); err != nil {
+				req.reply <- err
+				continue
+			}
+			staticPaths[mappingkey{req.info.Host, req.info.Prefix}] = req.info
 			addBackend(req.info)
 			req.reply <- nil
 
@@ -144,8 +181,25 @@ func backendManager() {
 					BackendsUnregistered.Inc()
 					BackendActive.Dec()
 					delete(mapping, mapkey)
+					backend.Stop()
+
+					if info, ok := staticPaths[mapkey]; ok && req.recreate {
+						addBackend(info)
+					}
 				}
 			}
+
+		case reply := <-shutdownChan:
+			var stopped []Backend
+			for mapkey, backend := range mapping {
+				log.Infof("Stopping backend %d -> '%s%s'", backend.ID(), mapkey.host, mapkey.prefix)
+				BackendActive.Dec()
+				delete(mapping, mapkey)
+				backend.Stop()
+				stopped = append(stopped, backend)
+			}
+			staticPaths = make(map[mappingkey]PathInfo)
+			reply <- stopped
 		}
 	}
 }
@@ -56,6 +56,28 @@ var (
 			Help: "Number of backends that have reconnected to SSH",
 		},
 	)
+	// BackendRunRestart allows the counting of restarts of run commands
+	BackendRunRestart = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "undergang_backend_run_restart_total",
+			Help: "Number of times run commands have been restarted",
+		},
+	)
+	// BackendIdle allows the counting of backends that have been disconnected due to being idle
+	BackendIdle = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "undergang_backend_idle_total",
+			Help: "Number of times backends have been disconnected due to being idle",
+		},
+	)
+	// BackendKeepaliveRTT allows the tracking of the round-trip time of SSH keepalives per backend
+	BackendKeepaliveRTT = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "undergang_backend_keepalive_rtt_seconds",
+			Help: "Round-trip time of the last SSH keepalive",
+		},
+		[]string{"host", "prefix"},
+	)
 	// BackendProvisioningDuration allows the histogram of provisioning durations
 	BackendProvisioningDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
@@ -72,6 +94,14 @@ var (
 			Buckets: []float64{1, 5, 10, 30, 1 * 60, 2 * 60, 3 * 60, 4 * 60, 5 * 60},
 		},
 	)
+	// BackendTeardown allows the counting of teardown commands and their result
+	BackendTeardown = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "undergang_backend_teardown_total",
+			Help: "Number of teardown commands that have been run",
+		},
+		[]string{"result"},
+	)
 	// BackendBootstrapDuration allows the histogram of provisioning durations
 	BackendBootstrapDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
@@ -90,7 +120,11 @@ func init() {
 	prometheus.MustRegister(BackendsUnregistered)
 	prometheus.MustRegister(BackendFailure)
 	prometheus.MustRegister(BackendReconnectSSH)
+	prometheus.MustRegister(BackendRunRestart)
+	prometheus.MustRegister(BackendIdle)
+	prometheus.MustRegister(BackendKeepaliveRTT)
 	prometheus.MustRegister(BackendProvisioningDuration)
 	prometheus.MustRegister(BackendConnectSSHDuration)
 	prometheus.MustRegister(BackendBootstrapDuration)
+	prometheus.MustRegister(BackendTeardown)
 }
@@ -1,17 +1,40 @@
 package app
 
 import (
-	"io"
 	"net"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/Sirupsen/logrus"
 )
 
+// How long closing a connection waits for the proxy command to be reaped.
+const proxyExitTimeout = 5 * time.Second
+
+// proxyConnection talks to a SSH server through the standard input and output of a
+// proxy command. The command is killed and reaped when the connection is closed.
 type proxyConnection struct {
-	cmd    *exec.Cmd
-	stdin  io.WriteCloser
-	stdout io.ReadCloser
+	cmd       *exec.Cmd
+	stdin     *os.File
+	stdout    *os.File
+	addr      proxyAddr
+	exited    chan bool
+	closeOnce sync.Once
+}
+
+// proxyAddr is the address that the proxy command connects to. It is needed
+// when verifying host keys, which looks at the remote address.
+type proxyAddr string
+
+func (a proxyAddr) Network() string {
+	return "proxy"
+}
+
+func (a proxyAddr) String() string {
+	return string(a)
 }
 
 func (p *proxyConnection) Read(b []byte) (n int, err error) {
@@ -23,7 +46,16 @@ func (p *proxyConnection) Write(b []byte) (n int, err error) {
 }
 
 func (p *proxyConnection) Close() error {
-	return p.cmd.Process.Kill()
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		p.stdout.Close()
+		killProxyProcess(p.cmd)
+	})
+	select {
+	case <-p.exited:
+	case <-time.After(proxyExitTimeout):
+	}
+	return nil
 }
 
 func (p *proxyConnection) LocalAddr() net.Addr {
@@ -31,32 +63,88 @@ func (p *proxyConnection) LocalAddr() net.Addr {
 }
 
 func (p *proxyConnection) RemoteAddr() net.Addr {
-	return nil
+	return p.addr
 }
 
 func (p *proxyConnection) SetDeadline(t time.Time) error {
-	return nil
+	if err := p.stdout.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return p.stdin.SetWriteDeadline(t)
 }
 
 func (p *proxyConnection) SetReadDeadline(t time.Time) error {
-	return nil
+	return p.stdout.SetReadDeadline(t)
 }
 
 func (p *proxyConnection) SetWriteDeadline(t time.Time) error {
-	return nil
+	return p.stdin.SetWriteDeadline(t)
+}
+
+// Expands %h (host), %p (port), %r (remote user) and %% in an OpenSSH-style ProxyCommand.
+// The values are quoted, as the command is run by the shell.
+func expandProxyCommand(template, address, user string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("%%", "%", "%h", shellQuote(host), "%p", shellQuote(port), "%r", shellQuote(user)).Replace(template), nil
 }
 
-func connectProxy(proxyCommand, address string) (net.Conn, error) {
+// Runs an OpenSSH-style ProxyCommand through the shell.
+func connectProxyTemplate(log *logrus.Entry, template, address, user string) (net.Conn, error) {
+	command, err := expandProxyCommand(template, address, user)
+	if err != nil {
+		return nil, err
+	}
+	return startProxy(log, exec.Command("/bin/sh", "-c", "exec "+command), address)
+}
+
+func connectProxy(log *logrus.Entry, proxyCommand, address string) (net.Conn, error) {
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		return nil, err
 	}
-	cmd := exec.Command(proxyCommand, host, port)
-	stdin, _ := cmd.StdinPipe()
-	stdout, _ := cmd.StdoutPipe()
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
+	return startProxy(log, exec.Command(proxyCommand, host, port), address)
+}
+
+func startProxy(log *logrus.Entry, cmd *exec.Cmd, address string) (net.Conn, error) {
+	log = log.WithField("proxy_command", strings.Join(cmd.Args, " "))
+
+	// Using our own pipes, as they support deadlines.
+	stdinReader, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdin.Close()
 		return nil, err
 	}
-	return &proxyConnection{cmd, stdin, stdout}, nil
+	stderr := &lineWriter{onLine: func(line string) {
+		log.Warnf("Proxy command: %s", line)
+	}}
+
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderr
+	setProxyProcessGroup(cmd)
+	err = cmd.Start()
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, err
+	}
+
+	p := &proxyConnection{cmd: cmd, stdin: stdin, stdout: stdout, addr: proxyAddr(address), exited: make(chan bool)}
+	go func() {
+		err := cmd.Wait()
+		stderr.Flush()
+		log.Infof("Proxy command exited: %v", err)
+		close(p.exited)
+	}()
+	return p, nil
 }
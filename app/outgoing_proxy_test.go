@@ -0,0 +1,33 @@
+package app
+
+import "testing"
+
+func TestExpandProxyCommand(t *testing.T) {
+	tests := []struct {
+		template string
+		address  string
+		user     string
+		expected string
+		err      bool
+	}{
+		{"nc %h %p", "example.com:22", "root", "nc 'example.com' '22'", false},
+		{"ssh -W %h:%p %r@bastion", "10.0.0.1:2222", "deploy", "ssh -W '10.0.0.1':'2222' 'deploy'@bastion", false},
+		{"nc %h %p", "[::1]:22", "root", "nc '::1' '22'", false},
+		{"echo 100%%", "example.com:22", "root", "echo 100%", false},
+		{"echo %%h", "example.com:22", "root", "echo %h", false},
+		{"nc %h %p", "a;reboot:22", "root", "nc 'a;reboot' '22'", false},
+		{"ssh %r@bastion", "example.com:22", "$(reboot)", "ssh '$(reboot)'@bastion", false},
+		{"ssh %r@bastion", "example.com:22", "it's", `ssh 'it'\''s'@bastion`, false},
+		{"nc %h %p", "example.com", "root", "", true},
+	}
+	for _, test := range tests {
+		actual, err := expandProxyCommand(test.template, test.address, test.user)
+		if (err != nil) != test.err {
+			t.Errorf("expandProxyCommand(%q, %q, %q) returned error %v", test.template, test.address, test.user, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("expandProxyCommand(%q, %q, %q) = %q, expected %q", test.template, test.address, test.user, actual, test.expected)
+		}
+	}
+}
@@ -43,7 +43,6 @@ type connection struct {
 // readPump pumps messages from the websocket connection to the hub.
 func (c *connection) readPump() {
 	defer func() {
-		// TODO: Unsubscribe from worker?
 		c.ws.Close()
 	}()
 	c.ws.SetReadLimit(maxMessageSize)
@@ -105,29 +104,117 @@ func serveProgressWebSocket(log *logrus.Entry, backend Backend, w http.ResponseW
 	progress := make(chan progressCmd, 256)
 	c := &connection{ws: ws, progress: progress}
 
-	backend.Subscribe(progress)
-
+	// The history is replayed as fast as it's written, so it must be written while subscribing.
 	go c.writePump()
+	backend.Subscribe(progress)
 	c.readPump()
+	backend.Unsubscribe(progress)
 	return true
 }
 
-func progressBroker(progressChan <-chan progressCmd, subscribeChan <-chan chan progressCmd) {
+// Number of bootstrap_output events per bootstrap step that are replayed to new subscribers
+const progressHistoryMaxOutput = 20
+
+// Adds an event to the progress history. Only the tail of the output of each bootstrap step,
+// its latest progress, and the latest event of every other kind is kept.
+func addProgressHistory(history []progressCmd, msg progressCmd) []progressCmd {
+	switch msg.Kind {
+	case "backend_idle", "backend_failed", "run_exited", "run_restarting":
+		// The backend is no longer ready, and new subscribers shouldn't see that it was.
+		history = history[:0]
+	}
+	if output, ok := msg.Data.(bootstrapOutput); ok && msg.Kind == "bootstrap_output" {
+		first, count := -1, 0
+		for idx, old := range history {
+			if o, ok := old.Data.(bootstrapOutput); ok && old.Kind == "bootstrap_output" && o.Step == output.Step {
+				if first < 0 {
+					first = idx
+				}
+				count++
+			}
+		}
+		if count >= progressHistoryMaxOutput {
+			history = append(history[:first], history[first+1:]...)
+		}
+	} else if progress, ok := msg.Data.(bootstrapProgress); ok && msg.Kind == "bootstrap_progress" {
+		for idx, old := range history {
+			if p, ok := old.Data.(bootstrapProgress); ok && old.Kind == "bootstrap_progress" && p.Step == progress.Step {
+				history = append(history[:idx], history[idx+1:]...)
+				break
+			}
+		}
+	} else {
+		for idx, old := range history {
+			if old.Kind == msg.Kind {
+				history = append(history[:idx], history[idx+1:]...)
+				break
+			}
+		}
+	}
+	return append(history, msg)
+}
+
+// Sends a new event to a subscriber without blocking. Returns false if the subscriber is too
+// slow to keep up.
+func sendProgress(sub chan progressCmd, msg progressCmd) bool {
+	select {
+	case sub <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func progressBroker(progressChan <-chan progressCmd, subscribeChan, unsubscribeChan <-chan chan progressCmd, done <-chan bool) {
 	progress := make([]progressCmd, 0)
 	subscribers := make([]chan progressCmd, 0)
+	// Closes the subscriber's channel, which ends its websocket.
+	unsubscribe := func(sub chan progressCmd) {
+		for idx, s := range subscribers {
+			if s == sub {
+				subscribers = append(subscribers[:idx], subscribers[idx+1:]...)
+				close(sub)
+				return
+			}
+		}
+	}
+	closeAll := func() {
+		for _, sub := range subscribers {
+			close(sub)
+		}
+	}
 	for {
 		select {
+		case <-done:
+			closeAll()
+			return
 		case msg := <-progressChan:
-			progress = append(progress, msg)
-			for _, sub := range subscribers {
-				sub <- msg
+			progress = addProgressHistory(progress, msg)
+			for _, sub := range append([]chan progressCmd(nil), subscribers...) {
+				if !sendProgress(sub, msg) {
+					unsubscribe(sub)
+				}
 			}
 		case q := <-subscribeChan:
-			// Send all old progress first
+			// Send all old progress first, waiting for the subscriber to take it unless it
+			// unsubscribes.
+			subscribers = append(subscribers, q)
+		replay:
 			for _, p := range progress {
-				q <- p
+				select {
+				case q <- p:
+				case u := <-unsubscribeChan:
+					unsubscribe(u)
+					if u == q {
+						break replay
+					}
+				case <-done:
+					closeAll()
+					return
+				}
 			}
-			subscribers = append(subscribers, q)
+		case q := <-unsubscribeChan:
+			unsubscribe(q)
 		}
 	}
 }
@@ -0,0 +1,107 @@
+package app
+
+import "testing"
+
+func TestAddProgressHistory(t *testing.T) {
+	var history []progressCmd
+	history = addProgressHistory(history, progressCmd{"bootstrap_status", nil})
+	for i := 0; i < progressHistoryMaxOutput+5; i++ {
+		history = addProgressHistory(history, progressCmd{"bootstrap_output", bootstrapOutput{Step: 0}})
+		history = addProgressHistory(history, progressCmd{"bootstrap_output", bootstrapOutput{Step: 1}})
+	}
+	if len(history) != 1+2*progressHistoryMaxOutput {
+		t.Errorf("History has %d events, expected %d", len(history), 1+2*progressHistoryMaxOutput)
+	}
+	if history[0].Kind != "bootstrap_status" {
+		t.Errorf("First event is %s, expected bootstrap_status", history[0].Kind)
+	}
+
+	history = addProgressHistory(history, progressCmd{"backend_idle", nil})
+	if len(history) != 1 || history[0].Kind != "backend_idle" {
+		t.Errorf("History after going idle is %v, expected only backend_idle", history)
+	}
+
+	history = addProgressHistory(history, progressCmd{"connection_start", nil})
+	for i := 0; i < 100; i++ {
+		history = addProgressHistory(history, progressCmd{"connection_try", i})
+		history = addProgressHistory(history, progressCmd{"connection_retry", i})
+	}
+	if len(history) != 4 {
+		t.Fatalf("History has %d events, expected only the latest of each kind", len(history))
+	}
+	if history[2].Kind != "connection_try" || history[2].Data != 99 || history[3].Kind != "connection_retry" {
+		t.Errorf("History is %v, expected the latest retry last", history)
+	}
+
+	history = addProgressHistory(history, progressCmd{"backend_failed", "connect_ssh"})
+	if len(history) != 1 || history[0].Kind != "backend_failed" {
+		t.Errorf("History after failing is %v, expected only backend_failed", history)
+	}
+}
+
+func TestProgressBrokerReplaysHistory(t *testing.T) {
+	progress := make(chan progressCmd)
+	subscribe := make(chan chan progressCmd)
+	unsubscribe := make(chan chan progressCmd)
+	done := make(chan bool)
+	defer close(done)
+	go progressBroker(progress, subscribe, unsubscribe, done)
+
+	for i := 0; i < 10; i++ {
+		progress <- progressCmd{"bootstrap_output", bootstrapOutput{Step: i}}
+	}
+
+	// The replay waits for subscribers that can't take all of the history at once.
+	slow := make(chan progressCmd, 1)
+	subscribe <- slow
+	for i := 0; i < 10; i++ {
+		if msg := <-slow; msg.Data.(bootstrapOutput).Step != i {
+			t.Errorf("Replayed event %d is %v", i, msg)
+		}
+	}
+	unsubscribe <- slow
+	if _, ok := <-slow; ok {
+		t.Errorf("Subscriber wasn't closed when unsubscribing")
+	}
+
+	// Unsubscribing during the replay ends it.
+	stuck := make(chan progressCmd)
+	subscribe <- stuck
+	unsubscribe <- stuck
+	if _, ok := <-stuck; ok {
+		t.Errorf("Subscriber wasn't closed when unsubscribing during the replay")
+	}
+	progress <- progressCmd{"connection_success", nil}
+}
+
+func TestProgressBrokerDropsSlowSubscribers(t *testing.T) {
+	progress := make(chan progressCmd)
+	subscribe := make(chan chan progressCmd)
+	unsubscribe := make(chan chan progressCmd)
+	done := make(chan bool)
+	defer close(done)
+	go progressBroker(progress, subscribe, unsubscribe, done)
+
+	slow := make(chan progressCmd, 1)
+	fast := make(chan progressCmd, 10)
+	subscribe <- slow
+	subscribe <- fast
+	progress <- progressCmd{"connection_start", nil}
+	progress <- progressCmd{"connection_success", nil}
+	unsubscribe <- fast
+
+	var received []progressCmd
+	for msg := range slow {
+		received = append(received, msg)
+	}
+	if len(received) != 1 {
+		t.Errorf("Slow subscriber received %d events before being dropped, expected 1", len(received))
+	}
+	received = nil
+	for msg := range fast {
+		received = append(received, msg)
+	}
+	if len(received) != 2 {
+		t.Errorf("Subscriber received %d events before unsubscribing, expected 2", len(received))
+	}
+}
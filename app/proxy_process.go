@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Starts the proxy command in its own process group, so that anything it spawns can be
+// killed together with it.
+func setProxyProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProxyProcess(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
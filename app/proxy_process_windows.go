@@ -0,0 +1,12 @@
+package app
+
+import (
+	"os/exec"
+)
+
+func setProxyProcessGroup(cmd *exec.Cmd) {
+}
+
+func killProxyProcess(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
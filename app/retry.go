@@ -0,0 +1,74 @@
+package app
+
+import (
+	"math/rand"
+	"time"
+)
+
+var defaultJitter = 0.2
+
+var defaultConnectRetry = configRetryPolicy{
+	InitialDelay: configDuration(1 * time.Second),
+	Multiplier:   2,
+	MaxDelay:     configDuration(30 * time.Second),
+	Jitter:       &defaultJitter,
+	Deadline:     configDuration(15 * time.Minute),
+}
+
+var defaultReconnectRetry = configRetryPolicy{
+	InitialDelay: configDuration(1 * time.Second),
+	Multiplier:   2,
+	MaxDelay:     configDuration(30 * time.Second),
+	Jitter:       &defaultJitter,
+	Deadline:     configDuration(5 * time.Minute),
+}
+
+// backoff calculates the delays between attempts of a retry policy.
+type backoff struct {
+	policy  configRetryPolicy
+	start   time.Time
+	delay   time.Duration
+	attempt int
+}
+
+// Creates a backoff for the policy, where unset fields are taken from the defaults.
+func newBackoff(policy *configRetryPolicy, defaults configRetryPolicy) *backoff {
+	p := defaults
+	if policy != nil {
+		if policy.InitialDelay != 0 {
+			p.InitialDelay = policy.InitialDelay
+		}
+		if policy.Multiplier != 0 {
+			p.Multiplier = policy.Multiplier
+		}
+		if policy.MaxDelay != 0 {
+			p.MaxDelay = policy.MaxDelay
+		}
+		if policy.Jitter != nil {
+			p.Jitter = policy.Jitter
+		}
+		if policy.Deadline != 0 {
+			p.Deadline = policy.Deadline
+		}
+	}
+	return &backoff{p, time.Now(), time.Duration(p.InitialDelay), 0}
+}
+
+// Returns the delay until the next attempt, or false if it would be past the deadline.
+func (b *backoff) next() (time.Duration, bool) {
+	b.attempt++
+	delay := b.delay
+	if b.policy.Jitter != nil && *b.policy.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + *b.policy.Jitter*(2*rand.Float64()-1)))
+	}
+
+	b.delay = time.Duration(float64(b.delay) * b.policy.Multiplier)
+	if b.delay > time.Duration(b.policy.MaxDelay) {
+		b.delay = time.Duration(b.policy.MaxDelay)
+	}
+
+	if time.Since(b.start)+delay > time.Duration(b.policy.Deadline) {
+		return 0, false
+	}
+	return delay, true
+}
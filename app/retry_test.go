@@ -0,0 +1,73 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	noJitter := 0.0
+	tests := []struct {
+		name     string
+		policy   *configRetryPolicy
+		defaults configRetryPolicy
+		expected []time.Duration
+	}{
+		{
+			name:     "defaults without jitter",
+			policy:   &configRetryPolicy{Jitter: &noJitter},
+			defaults: defaultConnectRetry,
+			expected: []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+				16 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name: "overridden fields",
+			policy: &configRetryPolicy{
+				InitialDelay: configDuration(100 * time.Millisecond),
+				Multiplier:   3,
+				MaxDelay:     configDuration(time.Second),
+				Jitter:       &noJitter,
+			},
+			defaults: defaultReconnectRetry,
+			expected: []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond,
+				time.Second},
+		},
+	}
+	for _, test := range tests {
+		b := newBackoff(test.policy, test.defaults)
+		for i, expected := range test.expected {
+			delay, ok := b.next()
+			if !ok {
+				t.Errorf("%s: attempt %d is past the deadline", test.name, i+1)
+				break
+			}
+			if delay != expected {
+				t.Errorf("%s: attempt %d has delay %v, expected %v", test.name, i+1, delay, expected)
+			}
+		}
+	}
+}
+
+func TestBackoffDeadline(t *testing.T) {
+	noJitter := 0.0
+	b := newBackoff(&configRetryPolicy{
+		InitialDelay: configDuration(time.Minute),
+		Jitter:       &noJitter,
+		Deadline:     configDuration(30 * time.Second),
+	}, defaultConnectRetry)
+	if delay, ok := b.next(); ok {
+		t.Errorf("Attempt has delay %v, expected to be past the deadline", delay)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	jitter := 0.5
+	b := newBackoff(&configRetryPolicy{InitialDelay: configDuration(time.Second), Multiplier: 1, Jitter: &jitter},
+		defaultConnectRetry)
+	for i := 0; i < 100; i++ {
+		delay, ok := b.next()
+		if !ok || delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("Attempt %d has delay %v, expected 500ms-1.5s", i+1, delay)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+var defaultRestartRetry = configRetryPolicy{
+	InitialDelay: configDuration(1 * time.Second),
+	Multiplier:   2,
+	MaxDelay:     configDuration(1 * time.Minute),
+	Jitter:       &defaultJitter,
+	Deadline:     configDuration(15 * time.Minute),
+}
+
+// A run command that has been running for this long is considered to have started fine, and
+// the restart backoff starts over the next time it exits.
+const runStableAfter = 5 * time.Minute
+
+// Number of output lines of the run command that are logged when it exits
+const runOutputTailLines = 20
+
+type runExit struct {
+	err    error
+	output string
+	ranFor time.Duration
+}
+
+// The result of restarting the run command, once it accepts connections or has failed to.
+type runRestart struct {
+	exited <-chan runExit
+	err    error
+}
+
+var errRunRestartCancelled = errors.New("Restart of the run command cancelled")
+
+type runExitStatus struct {
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Output     string `json:"output,omitempty"`
+	Restarting bool   `json:"restarting"`
+}
+
+// Starts the run command on a pseudo terminal. When it exits, it's reported on the returned
+// channel.
+func (b *backendStruct) startRun(client *ssh.Client) (<-chan runExit, error) {
+	switch b.info.SSHTunnel.Run.Restart {
+	case "", restartNever, restartOnFailure, restartAlways:
+	default:
+		return nil, fmt.Errorf("Unknown restart policy '%s'", b.info.SSHTunnel.Run.Restart)
+	}
+	rc, err := b.remoteCommand(*b.info.SSHTunnel.Run)
+	if err != nil {
+		return nil, err
+	}
+	matchOutput, err := b.runOutputMatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	b.log.Infof("Running command: '%s'", b.info.SSHTunnel.Run.Command)
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO: 0,
+	}
+
+	if err = session.RequestPty("xterm", 80, 40, modes); err != nil {
+		b.log.Warnf("request for pseudo terminal failed: %s", err)
+		session.Close()
+		return nil, err
+	}
+
+	// The pseudo terminal merges stderr into stdout.
+	tail := &lineTail{max: runOutputTailLines}
+	output := &lineWriter{onLine: func(line string) {
+		b.log.Debugf("Run: %s", line)
+		tail.add(line)
+		matchOutput(line)
+	}}
+	session.Stdout = output
+
+	start := time.Now()
+	if err = rc.start(session); err != nil {
+		session.Close()
+		return nil, err
+	}
+	exited := make(chan runExit, 1)
+	go func() {
+		err := session.Wait()
+		session.Close()
+		output.Flush()
+		exited <- runExit{err, tail.String(), time.Since(start)}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	return exited, nil
+}
+
+func (b *backendStruct) shouldRestartRun(exit runExit) bool {
+	switch b.info.SSHTunnel.Run.Restart {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return exit.err != nil
+	}
+	return false
+}
+
+// Handles the exit of the run command, restarting it according to its restart policy. The
+// backend isn't ready until the restarted command accepts connections. The restart happens in
+// the background, so that the SSH connection is still watched in the meantime, and its result
+// is sent on the returned channel. An error is returned if the command isn't restarted, as the
+// backend can't become ready again. Closing cancel abandons a restart that hasn't started the
+// command yet.
+func (b *backendStruct) superviseRun(client *ssh.Client, exit runExit, restarts **backoff, cancel chan bool) (<-chan runRestart, error) {
+	restart := b.shouldRestartRun(exit)
+	status := runExitStatus{ExitCode: exitCode(exit.err), Output: exit.output, Restarting: restart}
+	if exit.err != nil {
+		status.Error = exit.err.Error()
+		b.log.Warnf("Run command exited after %v: %v. Last output:\n%s", exit.ranFor, exit.err, exit.output)
+	} else {
+		b.log.Infof("Run command exited after %v. Last output:\n%s", exit.ranFor, exit.output)
+	}
+	b.isReady = false
+	if !restart {
+		b.progress <- progressCmd{"run_exited", status}
+		return nil, errors.New("Run command exited")
+	}
+
+	if *restarts == nil || exit.ranFor >= runStableAfter {
+		*restarts = newBackoff(b.info.SSHTunnel.Run.RestartRetry, defaultRestartRetry)
+	}
+	delay, ok := (*restarts).next()
+	if !ok {
+		b.progress <- progressCmd{"run_failed", "Restart limit reached"}
+		return nil, errors.New("Run command restart limit reached")
+	}
+
+	b.progress <- progressCmd{"run_restarting", status}
+	b.log.Infof("Restarting run command in %v", delay)
+	restarted := make(chan runRestart, 1)
+	go func() {
+		restarted <- b.restartRun(client, delay, cancel)
+	}()
+	return restarted, nil
+}
+
+func (b *backendStruct) restartRun(client *ssh.Client, delay time.Duration, cancel chan bool) runRestart {
+	select {
+	case <-time.After(delay):
+	case <-cancel:
+		return runRestart{err: errRunRestartCancelled}
+	case <-b.stop:
+		return runRestart{err: errBackendStopped}
+	}
+
+	BackendRunRestart.Inc()
+	exited, err := b.startRun(client)
+	if err == nil {
+		err = b.discoverAddress(client)
+	}
+	if err == nil {
+		err = b.waitBackend(client)
+	}
+	return runRestart{exited, err}
+}
@@ -35,7 +35,7 @@ func serveValidateServerAuth(backend Backend, w http.ResponseWriter, req *http.R
 	originalPath := strings.Replace(req.URL.Path, serverAuthEndpoint, "", 1)
 
 	if code := req.URL.Query().Get("code"); code != "" {
-		log.Info("Asking server %s about code '%s'", info.ServerAuth.ValidateURL, code)
+		log.Infof("Asking server %s about code '%s'", info.ServerAuth.ValidateURL, code)
 		gr := goreq.Request{
 			Method:      "POST",
 			Uri:         info.ServerAuth.ValidateURL,
@@ -59,10 +59,11 @@ func serveValidateServerAuth(backend Backend, w http.ResponseWriter, req *http.R
 			log.Info("Authentication server denied the validation code")
 			respond(log, w, req, "Authentication server denied code", http.StatusForbidden)
 		} else if ret.StatusCode != 200 {
-			log.Info("Authentication server returned unexpected status code %d", ret.StatusCode)
+			log.Infof("Authentication server returned unexpected status code %d", ret.StatusCode)
 			respond(log, w, req, "Authentication server unexpected result", http.StatusForbidden)
 		} else if ret.Body.FromJsonTo(&parsed) != nil || parsed.AccessToken == "" {
-			log.Info("Authentication server returned unexpected response: %v", ret.Body.ToString)
+			// The body has already been consumed by the JSON decoder.
+			log.Info("Authentication server returned unexpected response")
 			respond(log, w, req, "Authentication server unexpected response", http.StatusForbidden)
 		} else {
 			cookie := &http.Cookie{
@@ -0,0 +1,304 @@
+package app
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var sshAuthSock string
+
+const (
+	authMethodKey                 = "key"
+	authMethodCertificate         = "certificate"
+	authMethodAgent               = "agent"
+	authMethodPassword            = "password"
+	authMethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// agentSigners hands out the keys held by the ssh-agent. The agent is connected to on every
+// handshake, and the connection is kept open until the next one since the signers use it.
+type agentSigners struct {
+	lock sync.Mutex
+	conn net.Conn
+}
+
+func (a *agentSigners) Signers() ([]ssh.Signer, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+	if sshAuthSock == "" {
+		return nil, errors.New("No ssh-agent socket configured")
+	}
+	conn, err := net.Dial("unix", sshAuthSock)
+	if err != nil {
+		return nil, err
+	}
+	a.conn = conn
+	return agent.NewClient(conn).Signers()
+}
+
+// Returns a secret that is either read from a file, taken from an environment variable or
+// given inline, in that order of precedence.
+func readSecret(value, filename, env string) (string, error) {
+	if filename != "" {
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+	if env != "" {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("Environment variable %s is not set", env)
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+func (b *backendStruct) loadKey(server *configSSHServer) (key ssh.Signer, err error) {
+	sshKey := []byte(server.SSHKeyContents)
+	if server.SSHKeyFileName != "" {
+		sshKey, err = ioutil.ReadFile(server.SSHKeyFileName)
+		if err != nil {
+			b.progress <- progressCmd{"connection_failed", "Failed to read SSH key"}
+			return
+		}
+	}
+
+	key, err = ssh.ParsePrivateKey(sshKey)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		var passphrase string
+		passphrase, err = readSecret(server.SSHKeyPassphrase, server.SSHKeyPassphraseFile, server.SSHKeyPassphraseEnv)
+		if err != nil {
+			b.progress <- progressCmd{"connection_failed", "Failed to read SSH key passphrase"}
+			return
+		} else if passphrase == "" {
+			b.progress <- progressCmd{"connection_failed", "SSH key is encrypted, but no passphrase is configured"}
+			return nil, errors.New("SSH key is encrypted, but no passphrase is configured")
+		}
+
+		key, err = ssh.ParsePrivateKeyWithPassphrase(sshKey, []byte(passphrase))
+		if err == x509.IncorrectPasswordError {
+			b.progress <- progressCmd{"connection_failed", "Incorrect SSH key passphrase"}
+			return
+		}
+	}
+	if err != nil {
+		b.progress <- progressCmd{"connection_failed", "Failed to parse SSH key"}
+		return
+	}
+
+	if server.SSHCertificate != "" || server.SSHCertificateFileName != "" {
+		key, err = b.loadCertificate(server, key)
+	}
+	return
+}
+
+func defaultAuthMethods(server *configSSHServer) (methods []string) {
+	if server.SSHKeyContents != "" || server.SSHKeyFileName != "" {
+		methods = append(methods, authMethodKey)
+	}
+	if server.MintCertificate != nil {
+		methods = append(methods, authMethodCertificate)
+	}
+	if server.UseAgent {
+		methods = append(methods, authMethodAgent)
+	}
+	if server.Password != "" || server.PasswordFile != "" {
+		methods = append(methods, authMethodPassword)
+	}
+	if len(server.KeyboardInteractive) > 0 {
+		methods = append(methods, authMethodKeyboardInteractive)
+	}
+	return
+}
+
+// authLog remembers the authentication method that was used last during a handshake, which
+// is the one that succeeded if the handshake did.
+type authLog struct {
+	lock   sync.Mutex
+	method string
+}
+
+func (l *authLog) set(method string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.method = method
+}
+
+func (l *authLog) get() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.method
+}
+
+// trackedSigner records in the authLog when it is used for signing, which the SSH client
+// only does for keys that the server accepts.
+type trackedSigner struct {
+	ssh.AlgorithmSigner
+	method string
+	used   *authLog
+}
+
+func (s *trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.used.set(s.method)
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.used.set(s.method)
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+func trackSigners(signers []ssh.Signer, method string, used *authLog) []ssh.Signer {
+	ret := make([]ssh.Signer, len(signers))
+	for idx, signer := range signers {
+		if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+			ret[idx] = &trackedSigner{algorithmSigner, method, used}
+		} else {
+			ret[idx] = signer
+		}
+	}
+	return ret
+}
+
+type keyboardInteractiveAnswer struct {
+	prompt *regexp.Regexp
+	answer string
+}
+
+func keyboardInteractiveChallenge(answers []keyboardInteractiveAnswer, used *authLog) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		used.set(authMethodKeyboardInteractive)
+		replies := make([]string, len(questions))
+	nextQuestion:
+		for idx, question := range questions {
+			for _, answer := range answers {
+				if answer.prompt.MatchString(question) {
+					replies[idx] = answer.answer
+					continue nextQuestion
+				}
+			}
+			return nil, fmt.Errorf("No answer configured for prompt '%s'", question)
+		}
+		return replies, nil
+	}
+}
+
+func (b *backendStruct) loadKeyboardInteractive(server *configSSHServer) (answers []keyboardInteractiveAnswer, err error) {
+	for _, config := range server.KeyboardInteractive {
+		var answer keyboardInteractiveAnswer
+		if answer.prompt, err = regexp.Compile(config.Prompt); err != nil {
+			b.progress <- progressCmd{"connection_failed", "Invalid keyboard-interactive prompt"}
+			return
+		}
+		if answer.answer, err = readSecret(config.Answer, config.AnswerFile, ""); err != nil {
+			b.progress <- progressCmd{"connection_failed", "Failed to read keyboard-interactive answer"}
+			return
+		}
+		answers = append(answers, answer)
+	}
+	return
+}
+
+// Returns the authentication methods of the server in the order they should be tried. Key,
+// certificate and agent signers are merged into a single public key method, as the SSH client only tries each
+// kind of method once. The method that was used is recorded in the authLog.
+func (b *backendStruct) authMethods(server *configSSHServer, used *authLog) (methods []ssh.AuthMethod, err error) {
+	order := server.AuthMethods
+	if len(order) == 0 {
+		order = defaultAuthMethods(server)
+	}
+
+	var signers []func() ([]ssh.Signer, error)
+	publicKeys := ssh.PublicKeysCallback(func() (ret []ssh.Signer, err error) {
+		for _, source := range signers {
+			s, err := source()
+			if err != nil {
+				b.log.Warnf("Failed to get SSH keys: %v", err)
+				continue
+			}
+			ret = append(ret, s...)
+		}
+		return ret, nil
+	})
+
+	for _, method := range order {
+		switch method {
+		case authMethodKey:
+			var key ssh.Signer
+			if key, err = b.loadKey(server); err != nil {
+				return
+			}
+			signers = append(signers, func() ([]ssh.Signer, error) {
+				return trackSigners([]ssh.Signer{key}, authMethodKey, used), nil
+			})
+			if len(signers) == 1 {
+				methods = append(methods, publicKeys)
+			}
+		case authMethodCertificate:
+			var mint func() ([]ssh.Signer, error)
+			if mint, err = b.certificateMinter(server); err != nil {
+				return
+			}
+			signers = append(signers, func() ([]ssh.Signer, error) {
+				s, err := mint()
+				return trackSigners(s, authMethodCertificate, used), err
+			})
+			if len(signers) == 1 {
+				methods = append(methods, publicKeys)
+			}
+		case authMethodAgent:
+			agentKeys := &agentSigners{}
+			signers = append(signers, func() ([]ssh.Signer, error) {
+				s, err := agentKeys.Signers()
+				return trackSigners(s, authMethodAgent, used), err
+			})
+			if len(signers) == 1 {
+				methods = append(methods, publicKeys)
+			}
+		case authMethodPassword:
+			var password string
+			if password, err = readSecret(server.Password, server.PasswordFile, ""); err != nil {
+				b.progress <- progressCmd{"connection_failed", "Failed to read SSH password"}
+				return
+			}
+			methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+				used.set(authMethodPassword)
+				return password, nil
+			}))
+		case authMethodKeyboardInteractive:
+			var answers []keyboardInteractiveAnswer
+			if answers, err = b.loadKeyboardInteractive(server); err != nil {
+				return
+			}
+			methods = append(methods, ssh.KeyboardInteractive(keyboardInteractiveChallenge(answers, used)))
+		default:
+			b.progress <- progressCmd{"connection_failed", "Unknown SSH authentication method"}
+			return nil, fmt.Errorf("Unknown SSH authentication method '%s'", method)
+		}
+	}
+
+	if len(methods) == 0 {
+		b.progress <- progressCmd{"connection_failed", "No SSH authentication method configured"}
+		return nil, errors.New("No SSH authentication method configured")
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var sshCAKeyFile string
+
+const defaultCertificateValidity = 5 * time.Minute
+
+// Attaches the OpenSSH user certificate of the server configuration to the key.
+func (b *backendStruct) loadCertificate(server *configSSHServer, key ssh.Signer) (ssh.Signer, error) {
+	var err error
+	buf := []byte(server.SSHCertificate)
+	if server.SSHCertificateFileName != "" {
+		if buf, err = ioutil.ReadFile(server.SSHCertificateFileName); err != nil {
+			b.progress <- progressCmd{"connection_failed", "Failed to read SSH certificate"}
+			return nil, err
+		}
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		b.progress <- progressCmd{"connection_failed", "Failed to parse SSH certificate"}
+		return nil, err
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		b.progress <- progressCmd{"connection_failed", "Failed to parse SSH certificate"}
+		return nil, errors.New("SSH certificate is a plain public key")
+	}
+
+	signer, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		b.progress <- progressCmd{"connection_failed", "SSH certificate doesn't match the SSH key"}
+	}
+	return signer, err
+}
+
+// Generates a new key and a short-lived certificate for it, signed by the CA key.
+func mintCertificate(ca ssh.Signer, config *configMintCertificate, username, keyID string) (ssh.Signer, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	var serial [8]byte
+	if _, err = rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	principals := config.Principals
+	if len(principals) == 0 {
+		principals = []string{username}
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		// Allow for some clock skew
+		ValidAfter:  uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore: uint64(now.Add(config.Validity.or(defaultCertificateValidity)).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-port-forwarding": "",
+				"permit-pty":             "",
+			},
+		},
+	}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// Returns a function that mints a new certificate for every handshake.
+func (b *backendStruct) certificateMinter(server *configSSHServer) (func() ([]ssh.Signer, error), error) {
+	if server.MintCertificate == nil {
+		b.progress <- progressCmd{"connection_failed", "No certificate minting configured"}
+		return nil, errors.New("Certificate authentication requires mint_certificate")
+	}
+	if sshCAKeyFile == "" {
+		b.progress <- progressCmd{"connection_failed", "No SSH CA key configured"}
+		return nil, errors.New("No SSH CA key configured")
+	}
+	buf, err := ioutil.ReadFile(sshCAKeyFile)
+	if err != nil {
+		b.progress <- progressCmd{"connection_failed", "Failed to read SSH CA key"}
+		return nil, err
+	}
+	ca, err := ssh.ParsePrivateKey(buf)
+	if err != nil {
+		b.progress <- progressCmd{"connection_failed", "Failed to parse SSH CA key"}
+		return nil, err
+	}
+
+	keyID := fmt.Sprintf("undergang backend %d (%s%s)", b.id, b.info.Host, b.info.Prefix)
+	return func() ([]ssh.Signer, error) {
+		signer, err := mintCertificate(ca, server.MintCertificate, server.Username, keyID)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.Signer{signer}, nil
+	}, nil
+}
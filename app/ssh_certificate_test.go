@@ -0,0 +1,87 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func writeTestCAKey(t *testing.T, dir string) string {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "ca")
+	if err = ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCertificateMinter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "undergang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caKey := writeTestCAKey(t, dir)
+	garbage := filepath.Join(dir, "garbage")
+	if err = ioutil.WriteFile(garbage, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(file string) {
+		sshCAKeyFile = file
+	}(sshCAKeyFile)
+
+	tests := []struct {
+		name   string
+		caKey  string
+		server configSSHServer
+		err    bool
+	}{
+		{"minted", caKey, configSSHServer{Username: "deploy", MintCertificate: &configMintCertificate{}}, false},
+		{"no mint_certificate", caKey, configSSHServer{Username: "deploy"}, true},
+		{"no CA key", "", configSSHServer{Username: "deploy", MintCertificate: &configMintCertificate{}}, true},
+		{"missing CA key", filepath.Join(dir, "missing"), configSSHServer{MintCertificate: &configMintCertificate{}}, true},
+		{"invalid CA key", garbage, configSSHServer{MintCertificate: &configMintCertificate{}}, true},
+	}
+	for _, test := range tests {
+		sshCAKeyFile = test.caKey
+		b := &backendStruct{progress: make(chan progressCmd, 1)}
+		mint, err := b.certificateMinter(&test.server)
+		if (err != nil) != test.err {
+			t.Errorf("%s: returned error %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			if len(b.progress) != 1 {
+				t.Errorf("%s: didn't report the failure on the progress page", test.name)
+			}
+			continue
+		}
+
+		signers, err := mint()
+		if err != nil || len(signers) != 1 {
+			t.Errorf("%s: minting returned %v, %v", test.name, signers, err)
+			continue
+		}
+		cert, ok := signers[0].PublicKey().(*ssh.Certificate)
+		if !ok {
+			t.Errorf("%s: minted a plain key", test.name)
+			continue
+		}
+		if len(cert.ValidPrincipals) != 1 || cert.ValidPrincipals[0] != "deploy" {
+			t.Errorf("%s: minted a certificate for %v, expected the username", test.name, cert.ValidPrincipals)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var errCommandTimeout = errors.New("Command timed out")
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// remoteCommand is a command that is ready to be run on the SSH server.
+type remoteCommand struct {
+	command string
+	env     map[string]string
+	// Export the environment in the command if the server doesn't accept it
+	inlineEnvFallback bool
+	timeout           time.Duration
+	// The command is killed when this is closed, e.g. when the backend is stopped
+	stop <-chan bool
+}
+
+// The values that placeholders in commands and environment variables expand to, e.g.
+// {{.Host}}, {{.Prefix}} or {{.BackendID}}. Fields that aren't configured are empty.
+type commandTemplateData struct {
+	Host      string
+	Prefix    string
+	BackendID int
+	// The configured backend
+	BackendAddress string
+	BasePath       string
+	// The SSH server, and the user that's logged in to it
+	SSHAddress  string
+	SSHUsername string
+	// The user that's authenticated with basic auth
+	User string
+}
+
+func (b *backendStruct) commandTemplateData() commandTemplateData {
+	data := commandTemplateData{Host: b.info.Host, Prefix: b.info.Prefix, BackendID: b.id}
+	if b.info.Backend != nil {
+		data.BackendAddress = b.info.Backend.Address
+		data.BasePath = b.info.Backend.BasePath
+	}
+	if b.info.SSHTunnel != nil {
+		data.SSHAddress = b.info.SSHTunnel.Address
+		data.SSHUsername = b.info.SSHTunnel.Username
+	}
+	if b.info.BasicAuth != nil {
+		data.User = b.info.BasicAuth.Username
+	}
+	return data
+}
+
+func expandCommandTemplate(text string, data commandTemplateData) (string, error) {
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Merges the environment of the command with that of the tunnel, and expands the placeholders
+// of both if the command enables templates.
+func (b *backendStruct) remoteCommand(cmd configCommand) (rc remoteCommand, err error) {
+	data := b.commandTemplateData()
+	expand := func(text string) (string, error) {
+		if !cmd.Template {
+			return text, nil
+		}
+		return expandCommandTemplate(text, data)
+	}
+	if rc.command, err = expand(cmd.Command); err != nil {
+		return
+	}
+
+	rc.env = make(map[string]string)
+	for _, env := range []map[string]string{b.info.SSHTunnel.Env, cmd.Env} {
+		for name, value := range env {
+			if !envNameRegexp.MatchString(name) {
+				return rc, fmt.Errorf("Invalid environment variable name '%s'", name)
+			}
+			if rc.env[name], err = expand(value); err != nil {
+				return
+			}
+		}
+	}
+	rc.inlineEnvFallback = b.info.SSHTunnel.EnvInlineFallback
+	rc.timeout = time.Duration(cmd.Timeout)
+	return
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Starts the command in the session, after setting up its environment.
+func (c remoteCommand) start(session *ssh.Session) error {
+	names := make([]string, 0, len(c.env))
+	for name := range c.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	command := c.command
+	for _, name := range names {
+		if err := session.Setenv(name, c.env[name]); err != nil {
+			if !c.inlineEnvFallback {
+				return fmt.Errorf("Failed to set environment variable %s: %v", name, err)
+			}
+			var exports []string
+			for _, name := range names {
+				exports = append(exports, name+"="+shellQuote(c.env[name]))
+			}
+			command = "export " + strings.Join(exports, " ") + "; " + command
+			break
+		}
+	}
+	return session.Start(command)
+}
+
+// Runs a command in a new session on the SSH server. If a timeout is given, the command is
+// killed when it hasn't finished in time.
+func runCommand(client *ssh.Client, cmd remoteCommand, stdout, stderr io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if err = cmd.start(session); err != nil {
+		return err
+	}
+	if cmd.timeout <= 0 && cmd.stop == nil {
+		return session.Wait()
+	}
+
+	var timeout <-chan time.Time
+	if cmd.timeout > 0 {
+		timer := time.NewTimer(cmd.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- session.Wait()
+	}()
+	// Not all servers support signals, but closing the session hangs up on the command.
+	select {
+	case err = <-result:
+		return err
+	case <-timeout:
+		session.Signal(ssh.SIGKILL)
+		return errCommandTimeout
+	case <-cmd.stop:
+		session.Signal(ssh.SIGKILL)
+		return errBackendStopped
+	}
+}
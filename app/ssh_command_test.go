@@ -0,0 +1,93 @@
+package app
+
+import "testing"
+
+func TestExpandCommandTemplate(t *testing.T) {
+	data := commandTemplateData{Host: "example.com", Prefix: "/app/", BackendID: 7}
+	tests := []struct {
+		text     string
+		expected string
+		err      bool
+	}{
+		{"echo hello", "echo hello", false},
+		{"echo {{.Host}}{{.Prefix}} {{.BackendID}}", "echo example.com/app/ 7", false},
+		{"mkdir -p /srv/{{.BackendID}}", "mkdir -p /srv/7", false},
+		{"echo {{.SSHTunnel}}", "", true},
+		{"echo {{.Host", "", true},
+	}
+	for _, test := range tests {
+		actual, err := expandCommandTemplate(test.text, data)
+		if (err != nil) != test.err {
+			t.Errorf("expandCommandTemplate(%q) returned error %v", test.text, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("expandCommandTemplate(%q) = %q, expected %q", test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestCommandTemplateData(t *testing.T) {
+	b := &backendStruct{id: 7, info: PathInfo{
+		Host:   "example.com",
+		Prefix: "/app/",
+		SSHTunnel: &configSSHTunnel{configSSHServer: configSSHServer{
+			Address:  "ssh.example.com:22",
+			Username: "deploy",
+		}},
+		Backend:   &configBackend{Address: "localhost:8080", BasePath: "/base"},
+		BasicAuth: &configBasicAuth{Username: "alice", Password: "secret"},
+	}}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"{{.Host}}", "example.com"},
+		{"{{.Prefix}}", "/app/"},
+		{"{{.BackendID}}", "7"},
+		{"{{.BackendAddress}}", "localhost:8080"},
+		{"{{.BasePath}}", "/base"},
+		{"{{.SSHAddress}}", "ssh.example.com:22"},
+		{"{{.SSHUsername}}", "deploy"},
+		{"{{.User}}", "alice"},
+	}
+	for _, test := range tests {
+		actual, err := expandCommandTemplate(test.text, b.commandTemplateData())
+		if err != nil || actual != test.expected {
+			t.Errorf("expandCommandTemplate(%q) = %q, %v, expected %q", test.text, actual, err, test.expected)
+		}
+	}
+
+	// Without a tunnel, backend or basic auth, their fields are empty.
+	b = &backendStruct{id: 7, info: PathInfo{Host: "example.com"}}
+	actual, err := expandCommandTemplate("{{.BackendAddress}}{{.BasePath}}{{.SSHAddress}}{{.SSHUsername}}{{.User}}", b.commandTemplateData())
+	if err != nil || actual != "" {
+		t.Errorf("Unconfigured fields expanded to %q, %v, expected nothing", actual, err)
+	}
+}
+
+func TestRemoteCommandTemplate(t *testing.T) {
+	b := &backendStruct{id: 3, info: PathInfo{Host: "example.com", Prefix: "/", SSHTunnel: &configSSHTunnel{
+		Env: map[string]string{"APP": "app-{{.BackendID}}"},
+	}}}
+	tests := []struct {
+		cmd         configCommand
+		expected    string
+		expectedEnv string
+	}{
+		{configCommand{Command: "docker inspect --format '{{.State.Running}}' app"},
+			"docker inspect --format '{{.State.Running}}' app", "app-{{.BackendID}}"},
+		{configCommand{Command: "start {{.Host}}", Template: true}, "start example.com", "app-3"},
+	}
+	for _, test := range tests {
+		rc, err := b.remoteCommand(test.cmd)
+		if err != nil {
+			t.Errorf("remoteCommand(%q) returned error %v", test.cmd.Command, err)
+			continue
+		}
+		if rc.command != test.expected || rc.env["APP"] != test.expectedEnv {
+			t.Errorf("remoteCommand(%q) = %q with APP=%q, expected %q with APP=%q",
+				test.cmd.Command, rc.command, rc.env["APP"], test.expected, test.expectedEnv)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshHop is one of the SSH servers on the way to the tunnel's server. All but the
+// last are jump hosts.
+type sshHop struct {
+	address          string
+	config           *ssh.ClientConfig
+	auth             *authLog
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+}
+
+const defaultDialTimeout = 10 * time.Second
+const defaultHandshakeTimeout = 30 * time.Second
+
+type hopError struct {
+	hop     int
+	address string
+	err     error
+}
+
+func (e *hopError) Error() string {
+	return fmt.Sprintf("hop %d (%s): %v", e.hop, e.address, e.err)
+}
+
+func (e *hopError) Unwrap() error {
+	return e.err
+}
+
+// A failed connection attempt, as sent in progress events.
+type connectionFailure struct {
+	Hop     int    `json:"hop,omitempty"`
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error"`
+	Attempt int    `json:"attempt"`
+	// Seconds until the next attempt
+	NextRetry float64 `json:"next_retry"`
+}
+
+func retryFailure(err error, attempt int, delay time.Duration) connectionFailure {
+	failure := connectionFailure{Error: err.Error(), Attempt: attempt, NextRetry: delay.Seconds()}
+	if e, ok := err.(*hopError); ok {
+		failure.Hop = e.hop
+		failure.Address = e.address
+		failure.Error = e.err.Error()
+	}
+	return failure
+}
+
+func (b *backendStruct) logAuthentication() {
+	for _, hop := range b.sshHops {
+		b.log.Infof("Authenticated to SSH server %s using %s", hop.address, hop.auth.get())
+	}
+}
+
+// Connects to the first hop, either directly or through the configured proxy.
+func (b *backendStruct) dialFirstHop(address string) (net.Conn, error) {
+	timeout := b.info.SSHTunnel.DialTimeout.or(defaultDialTimeout)
+	if b.info.SSHTunnel.DialProxy != "" {
+		return dialThroughProxy(b.info.SSHTunnel.DialProxy, address, timeout)
+	} else if b.info.SSHTunnel.ProxyCommand != "" {
+		return connectProxyTemplate(b.log, b.info.SSHTunnel.ProxyCommand, address, b.sshHops[0].config.User)
+	} else if dialProxy != "" {
+		return dialThroughProxy(dialProxy, address, timeout)
+	} else if proxyCommand != "" {
+		return connectProxy(b.log, proxyCommand, address)
+	}
+	return net.DialTimeout(`tcp`, address, timeout)
+}
+
+// Performs the SSH handshake, closing the connection if it takes longer than the timeout.
+func handshake(conn net.Conn, hop sshHop) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	timer := time.AfterFunc(hop.handshakeTimeout, func() {
+		conn.Close()
+	})
+	c, chans, reqs, err := ssh.NewClientConn(conn, hop.address, hop.config)
+	if !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, nil, nil, errors.New("SSH handshake timed out")
+	}
+	return c, chans, reqs, err
+}
+
+// Connects to the hops in order, tunneling each connection through the previous hop. The
+// connections to the jump hosts are closed when the returned client is closed.
+func dialSSH(hops []sshHop, dialFirstHop func(address string) (net.Conn, error)) (*ssh.Client, error) {
+	var client *ssh.Client
+	for idx, hop := range hops {
+		var conn net.Conn
+		var err error
+
+		if client != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), hop.dialTimeout)
+			conn, err = client.DialContext(ctx, "tcp", hop.address)
+			cancel()
+		} else {
+			conn, err = dialFirstHop(hop.address)
+		}
+
+		var c ssh.Conn
+		var chans <-chan ssh.NewChannel
+		var reqs <-chan *ssh.Request
+		if err == nil {
+			c, chans, reqs, err = handshake(conn, hop)
+		}
+		if err != nil {
+			if client != nil {
+				client.Close()
+			}
+			return nil, &hopError{idx + 1, hop.address, err}
+		}
+
+		next := ssh.NewClient(c, chans, reqs)
+		if client != nil {
+			go func(previous *ssh.Client) {
+				next.Wait()
+				previous.Close()
+			}(client)
+		}
+		client = next
+	}
+	return client, nil
+}
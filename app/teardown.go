@@ -0,0 +1,46 @@
+package app
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultTeardownTimeout = 30 * time.Second
+
+// Runs the teardown commands, e.g. to stop what was started by bootstrap and run. Failing
+// commands are logged, but don't prevent the remaining ones from running.
+func (b *backendStruct) teardown(client *ssh.Client) {
+	for _, cmd := range b.info.SSHTunnel.Teardown {
+		b.log.Infof("Started running teardown '%s'", cmd.Command)
+		start := time.Now()
+
+		stdout := &lineWriter{onLine: func(line string) {
+			b.log.Infof("Teardown '%s': %s", cmd.Command, line)
+		}}
+		stderr := &lineWriter{onLine: func(line string) {
+			b.log.Warnf("Teardown '%s': %s", cmd.Command, line)
+		}}
+		rc, err := b.remoteCommand(cmd)
+		if err == nil {
+			rc.timeout = cmd.Timeout.or(defaultTeardownTimeout)
+			err = runCommand(client, rc, stdout, stderr)
+			stdout.Flush()
+			stderr.Flush()
+		}
+
+		result := "success"
+		if err == errCommandTimeout {
+			result = "timeout"
+		} else if err != nil {
+			result = "failure"
+		}
+		BackendTeardown.With(prometheus.Labels{"result": result}).Inc()
+		if err != nil {
+			b.log.Warnf("Failed running teardown '%s' after %v: %v", cmd.Command, time.Since(start), err)
+		} else {
+			b.log.Infof("Finished running teardown '%s' in %v", cmd.Command, time.Since(start))
+		}
+	}
+}
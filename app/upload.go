@@ -0,0 +1,186 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/franela/goreq"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultUploadMode = 0644
+const checksumTimeout = 30 * time.Second
+
+// Returns the contents of the upload, which is either read from a local file, fetched from a
+// URL or given inline as base64.
+func (u configUpload) contents() ([]byte, error) {
+	if u.FileName != "" {
+		return ioutil.ReadFile(u.FileName)
+	} else if u.URL != "" {
+		ret, err := goreq.Request{
+			Uri:       u.URL,
+			UserAgent: "Undergang/" + undergangVersion,
+			Timeout:   60 * time.Second,
+		}.Do()
+		if err != nil {
+			return nil, err
+		}
+		defer ret.Body.Close()
+		if ret.StatusCode != 200 {
+			return nil, fmt.Errorf("Fetching %s returned unexpected status code %d", u.URL, ret.StatusCode)
+		}
+		return ioutil.ReadAll(ret.Body)
+	}
+	return base64.StdEncoding.DecodeString(u.Contents)
+}
+
+func (u configUpload) mode() (os.FileMode, error) {
+	if u.Mode == "" {
+		return defaultUploadMode, nil
+	}
+	mode, err := strconv.ParseUint(u.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid mode '%s'", u.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Parses the output of sha256sum.
+func parseSHA256Sum(output string) ([]byte, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return nil, errors.New("No checksum in sha256sum output")
+	}
+	checksum, err := hex.DecodeString(fields[0])
+	if err != nil || len(checksum) != sha256.Size {
+		return nil, fmt.Errorf("Invalid checksum '%s' in sha256sum output", fields[0])
+	}
+	return checksum, nil
+}
+
+// Returns the SHA256 checksum of the remote file, or nil if it doesn't exist or differs in size.
+// The checksum is calculated on the SSH server with sha256sum, and only if that isn't available,
+// by downloading the file.
+func (b *backendStruct) remoteChecksum(client *ssh.Client, sftpClient *sftp.Client, target string, size int64) ([]byte, error) {
+	info, err := sftpClient.Stat(target)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if info.Size() != size {
+		return nil, nil
+	}
+
+	var stdout bytes.Buffer
+	rc := remoteCommand{command: "sha256sum " + shellQuote(target), timeout: checksumTimeout, stop: b.stop}
+	err = runCommand(client, rc, &stdout, ioutil.Discard)
+	if err == nil {
+		var checksum []byte
+		if checksum, err = parseSHA256Sum(stdout.String()); err == nil {
+			return checksum, nil
+		}
+	}
+	if err == errBackendStopped {
+		return nil, err
+	}
+	b.log.Infof("Downloading '%s' to compare it, as sha256sum failed: %v", target, err)
+
+	f, err := sftpClient.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// Uploads a file to the SSH server, unless it's already there with the same contents.
+// Returns false if the upload was skipped.
+func (b *backendStruct) upload(client *ssh.Client, sftpClient *sftp.Client, upload configUpload) (uploaded bool, err error) {
+	target := upload.Target
+	if upload.Template {
+		if target, err = expandCommandTemplate(target, b.commandTemplateData()); err != nil {
+			return
+		}
+	}
+	mode, err := upload.mode()
+	if err != nil {
+		return
+	}
+	contents, err := upload.contents()
+	if err != nil {
+		return
+	}
+
+	checksum := sha256.Sum256(contents)
+	existing, err := b.remoteChecksum(client, sftpClient, target, int64(len(contents)))
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(existing, checksum[:]) {
+		if err = sftpClient.MkdirAll(path.Dir(target)); err != nil {
+			return
+		}
+		var f *sftp.File
+		if f, err = sftpClient.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err != nil {
+			return
+		}
+		_, err = f.Write(contents)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return
+		}
+		uploaded = true
+	}
+	err = sftpClient.Chmod(target, mode)
+	return
+}
+
+// Uploads the files of the tunnel. The uploads are the first steps of the bootstrap status.
+func (b *backendStruct) uploadFiles(client *ssh.Client, sftpClient *sftp.Client, status *bootstrapStatus) error {
+	for idx, upload := range b.info.SSHTunnel.Uploads {
+		b.log.Infof("Started uploading '%s'", upload.Target)
+		status.Steps[idx].Status = "started"
+		b.progress <- progressCmd{"bootstrap_status", status.copy()}
+
+		uploaded, err := b.upload(client, sftpClient, upload)
+		if err == errBackendStopped {
+			return err
+		} else if err != nil {
+			b.log.Warnf("Failed uploading '%s': %v", upload.Target, err)
+			status.Steps[idx].Status = "failed"
+			status.Steps[idx].Error = err.Error()
+			b.progress <- progressCmd{"bootstrap_status", status.copy()}
+			return fmt.Errorf("Upload of '%s' failed: %v", upload.Target, err)
+		}
+
+		if uploaded {
+			status.Steps[idx].Status = "done"
+			b.log.Infof("Finished uploading '%s'", upload.Target)
+		} else {
+			status.Steps[idx].Status = "skipped"
+			b.log.Infof("Skipped uploading '%s', as it's already up to date", upload.Target)
+		}
+		b.progress <- progressCmd{"bootstrap_status", status.copy()}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseSHA256Sum(t *testing.T) {
+	const sum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		output   string
+		expected string
+		err      bool
+	}{
+		{sum + "  /srv/app/config.json\n", sum, false},
+		{sum + " */srv/app/it's here\n", sum, false},
+		{"", "", true},
+		{"sha256sum: command not found\n", "", true},
+		{"e3b0c442  /srv/app/config.json\n", "", true},
+	}
+	for _, test := range tests {
+		actual, err := parseSHA256Sum(test.output)
+		if (err != nil) != test.err {
+			t.Errorf("parseSHA256Sum(%q) returned error %v", test.output, err)
+			continue
+		}
+		if hex.EncodeToString(actual) != test.expected {
+			t.Errorf("parseSHA256Sum(%q) = %x, expected %s", test.output, actual, test.expected)
+		}
+	}
+}
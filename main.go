@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	ug "github.com/boivie/undergang/app"
@@ -34,6 +38,41 @@ func main() {
 			Name:  "sshproxy",
 			Usage: "Optional utility for proxying SSH connections",
 		},
+		cli.StringFlag{
+			Name:  "ssh-dial-proxy",
+			Usage: "Optional SOCKS5 (socks5://) or HTTP CONNECT (http://) proxy for SSH connections",
+		},
+		cli.StringFlag{
+			Name:  "known-hosts",
+			Usage: "OpenSSH known_hosts file used to verify SSH host keys",
+		},
+		cli.BoolFlag{
+			Name:  "known-hosts-tofu",
+			Usage: "Trust unknown SSH hosts on first use and record their keys in the known_hosts file",
+		},
+		cli.BoolFlag{
+			Name:  "insecure-accept-any-host-key",
+			Usage: "Don't verify SSH host keys, unless a tunnel pins one or a known_hosts file is given",
+		},
+		cli.StringFlag{
+			Name:   "ssh-auth-sock",
+			Usage:  "Socket of the ssh-agent used for agent authentication",
+			EnvVar: "SSH_AUTH_SOCK",
+		},
+		cli.StringFlag{
+			Name:  "ssh-ca-key",
+			Usage: "SSH CA key used to mint short-lived certificates for tunnels",
+		},
+		cli.DurationFlag{
+			Name:  "failed-backend-cooldown",
+			Value: 30 * time.Second,
+			Usage: "Time before a failed backend is replaced by a new one",
+		},
+		cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Value: time.Minute,
+			Usage: "Time to wait for requests to finish and backends to be torn down when shutting down",
+		},
 		cli.StringFlag{
 			Name:  "config",
 			Usage: "Configuration file",
@@ -57,7 +96,21 @@ func main() {
 `)
 		log.Info("Version " + version)
 
-		ug.Init(c.String("pathinfo"), c.String("sshproxy"), version)
+		err := ug.Init(ug.Options{
+			PathInfoURL:              c.String("pathinfo"),
+			ProxyCommand:             c.String("sshproxy"),
+			DialProxy:                c.String("ssh-dial-proxy"),
+			KnownHostsFile:           c.String("known-hosts"),
+			KnownHostsTOFU:           c.Bool("known-hosts-tofu"),
+			InsecureAcceptAnyHostKey: c.Bool("insecure-accept-any-host-key"),
+			SSHAuthSock:              c.String("ssh-auth-sock"),
+			SSHCAKeyFile:             c.String("ssh-ca-key"),
+			FailedBackendCooldown:    c.Duration("failed-backend-cooldown"),
+			Version:                  version,
+		})
+		if err != nil {
+			panic(err)
+		}
 
 		if c.String("config") != "" {
 			var config struct {
@@ -71,15 +124,37 @@ func main() {
 				panic(err)
 			}
 			for _, path := range config.Paths {
-				ug.AddPath(path)
+				if err = ug.AddPath(path); err != nil {
+					panic(err)
+				}
 			}
 		}
 
+		server := &http.Server{Addr: c.String("listen")}
+		stopped := make(chan bool)
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			log.Infof("Received %v - shutting down", <-signals)
+
+			// Let ongoing requests finish, then tear down the backends.
+			ctx, cancel := context.WithTimeout(context.Background(), c.Duration("shutdown-timeout"))
+			defer cancel()
+			requestsCtx, requestsCancel := context.WithTimeout(ctx, 10*time.Second)
+			defer requestsCancel()
+			server.Shutdown(requestsCtx)
+			if err := ug.Shutdown(ctx); err != nil {
+				log.Warnf("Gave up waiting for backends to be torn down: %v", err)
+			}
+			close(stopped)
+		}()
+
 		log.Infof("Accepting requests on %s", c.String("listen"))
-		err := http.ListenAndServe(c.String("listen"), nil)
-		if err != nil {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
 			panic(err)
 		}
+		<-stopped
+		log.Info("Shut down")
 	}
 
 	app.Run(os.Args)